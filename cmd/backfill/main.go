@@ -0,0 +1,144 @@
+// backfill 为likes不为空而like_embedding为NULL的已有用户计算喜好向量
+//
+// 使用与服务相同的配置文件（./configs/config.yaml），向量由同一embedding配置计算：
+//
+//	go run ./cmd/backfill [-reset] [-metrics-address :6061]
+//
+// 每批处理完成后在Redis保存进度，中断后重新运行会从上次的位置继续
+//
+// -usernames 改为用服务的规范化规则写入已有用户的username_normalized，
+// 需要在 user_004 迁移之后、user_006 迁移之前运行：
+//
+//	go run ./cmd/backfill -usernames
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"tx/internal/backfill"
+	"tx/internal/config"
+	"tx/internal/service"
+	"tx/pkg/db"
+	"tx/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	reset := flag.Bool("reset", false, "忽略已保存的进度，从第一个用户开始")
+	metricsAddress := flag.String("metrics-address", "", "在该地址的 /debug/vars 导出进度指标，为空表示不导出")
+	usernames := flag.Bool("usernames", false, "回填用户名的规范形式而不是喜好向量")
+	flag.Parse()
+
+	log, err := logger.NewLogger()
+	if err != nil {
+		panic(err)
+	}
+	defer log.Sync()
+
+	if *usernames {
+		err = runUsernames(log)
+	} else {
+		err = run(log, *reset, *metricsAddress)
+	}
+	if err != nil {
+		log.Error("backfill failed", zap.Error(err))
+		os.Exit(1)
+	}
+}
+
+func run(log *zap.Logger, reset bool, metricsAddress string) error {
+	// 收到中断信号时停止，已完成的批次不会重复处理
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+	pool, err := db.NewPostgresClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	redisClient, err := db.NewRedisClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer redisClient.Close()
+	embedder, err := service.NewEmbedder(cfg)
+	if err != nil {
+		return err
+	}
+
+	checkpoint := backfill.NewRedisCheckpoint(redisClient, cfg)
+	if reset {
+		if err := checkpoint.Clear(ctx); err != nil {
+			return err
+		}
+	}
+	if metricsAddress != "" {
+		if err := serveMetrics(log, metricsAddress); err != nil {
+			return err
+		}
+	}
+
+	job, err := backfill.NewEmbeddingBackfill(pool, embedder, checkpoint, log, cfg)
+	if err != nil {
+		return err
+	}
+	stats, err := job.Run(ctx)
+	if errors.Is(err, context.Canceled) {
+		log.Info("backfill interrupted, rerun to resume", zap.String("lastId", stats.LastID))
+		return nil
+	}
+	return err
+}
+
+// runUsernames 回填username_normalized，只需要Postgres
+func runUsernames(log *zap.Logger) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.NewConfig()
+	if err != nil {
+		return err
+	}
+	pool, err := db.NewPostgresClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	job, err := backfill.NewUsernameBackfill(pool, log, cfg)
+	if err != nil {
+		return err
+	}
+	_, err = job.Run(ctx)
+	return err
+}
+
+// serveMetrics 在后台导出expvar指标
+func serveMetrics(log *zap.Logger, address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	log.Info("serving backfill metrics", zap.String("address", listener.Addr().String()))
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Warn("metrics server stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
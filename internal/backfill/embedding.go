@@ -0,0 +1,248 @@
+// Package backfill 为已有用户补齐喜好向量和用户名的规范形式
+package backfill
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"time"
+
+	"tx/internal/config"
+	"tx/internal/service"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// metrics 回填进度，通过expvar在 /debug/vars 的 backfill_like_embedding 下导出
+var metrics = expvar.NewMap("backfill_like_embedding")
+
+// 导出的指标
+var (
+	metricScanned = new(expvar.Int) // 已读取的用户数
+	metricUpdated = new(expvar.Int) // 已写入向量的用户数
+	metricSkipped = new(expvar.Int) // 喜好没有内容或读取后已被修改而跳过的用户数
+	metricFailed  = new(expvar.Int) // 计算向量失败的用户数
+	metricBatches = new(expvar.Int) // 已完成的批次数
+	metricLastID  = new(expvar.String)
+)
+
+func init() {
+	metrics.Set("scanned", metricScanned)
+	metrics.Set("updated", metricUpdated)
+	metrics.Set("skipped", metricSkipped)
+	metrics.Set("failed", metricFailed)
+	metrics.Set("batches", metricBatches)
+	metrics.Set("last_id", metricLastID)
+}
+
+// Checkpoint 保存已处理到的用户ID，中断后从这里继续
+type Checkpoint interface {
+	// Load 返回上次保存的用户ID，没有时返回空字符串
+	Load(ctx context.Context) (string, error)
+	Save(ctx context.Context, lastID string) error
+	// Clear 全部处理完成后清除，下次运行从头扫描
+	Clear(ctx context.Context) error
+}
+
+// RedisCheckpoint 保存在Redis中的检查点
+type RedisCheckpoint struct {
+	redis *redis.Client
+	key   string
+}
+
+// NewRedisCheckpoint 创建Redis检查点
+func NewRedisCheckpoint(redis *redis.Client, cfg *config.Config) *RedisCheckpoint {
+	return &RedisCheckpoint{redis: redis, key: cfg.Backfill.CheckpointKey}
+}
+
+// Load 读取检查点
+func (c *RedisCheckpoint) Load(ctx context.Context) (string, error) {
+	lastID, err := c.redis.Get(ctx, c.key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return lastID, err
+}
+
+// Save 保存检查点
+func (c *RedisCheckpoint) Save(ctx context.Context, lastID string) error {
+	return c.redis.Set(ctx, c.key, lastID, 0).Err()
+}
+
+// Clear 删除检查点
+func (c *RedisCheckpoint) Clear(ctx context.Context) error {
+	return c.redis.Del(ctx, c.key).Err()
+}
+
+// Stats 一次运行的统计
+type Stats struct {
+	Scanned int
+	Updated int
+	Skipped int
+	Failed  int
+	Batches int
+	LastID  string
+}
+
+// EmbeddingBackfill 为likes不为空而like_embedding为NULL的用户计算向量
+// 按用户ID的keyset分页扫描，每批处理完成后保存检查点；计算失败的用户保持NULL，清除检查点后重新运行时再次处理
+type EmbeddingBackfill struct {
+	db         *pgxpool.Pool
+	embedder   service.Embedder
+	checkpoint Checkpoint
+	logger     *zap.Logger
+	cfg        config.BackfillConfig
+}
+
+// NewEmbeddingBackfill 创建回填任务，embedder必须与在线服务使用同一配置，向量才能相互比较
+func NewEmbeddingBackfill(db *pgxpool.Pool, embedder service.Embedder, checkpoint Checkpoint, logger *zap.Logger, cfg *config.Config) (*EmbeddingBackfill, error) {
+	if cfg.Backfill.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid backfill batch size %d", cfg.Backfill.BatchSize)
+	}
+	return &EmbeddingBackfill{
+		db:         db,
+		embedder:   embedder,
+		checkpoint: checkpoint,
+		logger:     logger.Named("backfill"),
+		cfg:        cfg.Backfill,
+	}, nil
+}
+
+// pendingUser 待回填的用户
+type pendingUser struct {
+	id    string
+	likes string
+}
+
+// Run 从检查点开始处理到最后一个用户，全部完成后清除检查点
+// ctx取消时返回，未完成的批次在下次运行时重新处理
+func (b *EmbeddingBackfill) Run(ctx context.Context) (Stats, error) {
+	var stats Stats
+	lastID, err := b.checkpoint.Load(ctx)
+	if err != nil {
+		return stats, err
+	}
+	if lastID != "" {
+		b.logger.Info("resuming from checkpoint", zap.String("lastId", lastID))
+	}
+	stats.LastID = lastID
+	start := time.Now()
+
+	for {
+		users, err := b.nextBatch(ctx, lastID)
+		if err != nil {
+			return stats, err
+		}
+		if len(users) == 0 {
+			break
+		}
+		if err := b.process(ctx, users, &stats); err != nil {
+			return stats, err
+		}
+		lastID = users[len(users)-1].id
+		if err := b.checkpoint.Save(ctx, lastID); err != nil {
+			return stats, err
+		}
+		stats.Batches++
+		stats.LastID = lastID
+		metricBatches.Add(1)
+		metricLastID.Set(lastID)
+		b.logger.Info("backfill batch done",
+			zap.Int("batch", stats.Batches),
+			zap.Int("scanned", stats.Scanned),
+			zap.Int("updated", stats.Updated),
+			zap.Int("skipped", stats.Skipped),
+			zap.Int("failed", stats.Failed),
+			zap.String("lastId", lastID),
+			zap.Float64("usersPerSecond", float64(stats.Scanned)/time.Since(start).Seconds()))
+
+		if len(users) < b.cfg.BatchSize {
+			break
+		}
+		// 批次之间暂停，避免占满数据库和embedding服务
+		select {
+		case <-ctx.Done():
+			return stats, ctx.Err()
+		case <-time.After(b.cfg.BatchPause):
+		}
+	}
+
+	if err := b.checkpoint.Clear(ctx); err != nil {
+		return stats, err
+	}
+	b.logger.Info("backfill completed",
+		zap.Int("scanned", stats.Scanned),
+		zap.Int("updated", stats.Updated),
+		zap.Int("skipped", stats.Skipped),
+		zap.Int("failed", stats.Failed),
+		zap.Duration("elapsed", time.Since(start)))
+	return stats, nil
+}
+
+// nextBatch 读取lastID之后的一批待回填用户
+func (b *EmbeddingBackfill) nextBatch(ctx context.Context, lastID string) ([]pendingUser, error) {
+	rows, err := b.db.Query(ctx, `
+		SELECT id, likes FROM users
+		WHERE id > $1 AND likes IS NOT NULL AND like_embedding IS NULL
+		ORDER BY id
+		LIMIT $2`, lastID, b.cfg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (pendingUser, error) {
+		var u pendingUser
+		err := row.Scan(&u.id, &u.likes)
+		return u, err
+	})
+}
+
+// process 计算一批用户的向量并在一次往返中写入
+func (b *EmbeddingBackfill) process(ctx context.Context, users []pendingUser, stats *Stats) error {
+	batch := &pgx.Batch{}
+	for _, u := range users {
+		stats.Scanned++
+		metricScanned.Add(1)
+		embedding, err := b.embedder.Embed(ctx, u.likes)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			stats.Failed++
+			metricFailed.Add(1)
+			b.logger.Warn("embed likes failed", zap.String("userId", u.id), zap.Error(err))
+			continue
+		}
+		if embedding == nil {
+			stats.Skipped++
+			metricSkipped.Add(1)
+			continue
+		}
+		// 读取之后用户可能通过UpdateProfile修改了喜好，只在喜好未变且仍没有向量时写入
+		batch.Queue("UPDATE users SET like_embedding = $2 WHERE id = $1 AND likes = $3 AND like_embedding IS NULL",
+			u.id, embedding, u.likes)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	results := b.db.SendBatch(ctx, batch)
+	defer results.Close()
+	for range batch.Len() {
+		tag, err := results.Exec()
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 1 {
+			stats.Updated++
+			metricUpdated.Add(1)
+		} else {
+			stats.Skipped++
+			metricSkipped.Add(1)
+		}
+	}
+	return results.Close()
+}
@@ -0,0 +1,152 @@
+package backfill
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	"tx/internal/config"
+	"tx/internal/service"
+	"tx/pkg/db"
+	"tx/pkg/db/dbtest"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// memoryCheckpoint records every saved position.
+type memoryCheckpoint struct {
+	lastID  string
+	saved   []string
+	cleared bool
+}
+
+func (c *memoryCheckpoint) Load(context.Context) (string, error) { return c.lastID, nil }
+
+func (c *memoryCheckpoint) Save(_ context.Context, lastID string) error {
+	c.lastID = lastID
+	c.saved = append(c.saved, lastID)
+	return nil
+}
+
+func (c *memoryCheckpoint) Clear(context.Context) error {
+	c.lastID, c.cleared = "", true
+	return nil
+}
+
+// flakyEmbedder fails for likes containing "error".
+type flakyEmbedder struct{ service.Embedder }
+
+func (e flakyEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if strings.Contains(text, "error") {
+		return nil, errors.New("embedding service unavailable")
+	}
+	return e.Embedder.Embed(ctx, text)
+}
+
+// newUsersServer serves the pending users in likes, keyed by ID.
+// UPDATE affects no rows for IDs in modified, as if their likes changed after they were read.
+func newUsersServer(t *testing.T, likes map[string]string, modified map[string]bool) *dbtest.Server {
+	srv := dbtest.NewServer(t)
+	srv.HandleVector()
+	srv.Handle("SELECT id, likes FROM users", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID, pgtype.Int8OID},
+		Columns:   []dbtest.Column{{Name: "id", OID: pgtype.TextOID}, {Name: "likes", OID: pgtype.TextOID}},
+		Rows: func(q dbtest.Query) [][]any {
+			lastID, limit := string(q.Params[0]), int(binary.BigEndian.Uint64(q.Params[1]))
+			var ids []string
+			for id := range likes {
+				if id > lastID {
+					ids = append(ids, id)
+				}
+			}
+			sort.Strings(ids)
+			var rows [][]any
+			for _, id := range ids[:min(limit, len(ids))] {
+				rows = append(rows, []any{id, likes[id]})
+			}
+			return rows
+		},
+	})
+	srv.Handle("UPDATE users SET like_embedding = $2", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID, dbtest.VectorOID, pgtype.TextOID},
+		TagFunc: func(q dbtest.Query) string {
+			if modified[string(q.Params[0])] {
+				return "UPDATE 0"
+			}
+			return "UPDATE 1"
+		},
+	})
+	return srv
+}
+
+func updatedIDs(srv *dbtest.Server) []string {
+	var ids []string
+	for _, q := range srv.Queries() {
+		if strings.HasPrefix(q.SQL, "UPDATE") {
+			ids = append(ids, string(q.Params[0]))
+		}
+	}
+	return ids
+}
+
+func newBackfill(t *testing.T, srv *dbtest.Server, checkpoint Checkpoint) *EmbeddingBackfill {
+	cfg := &config.Config{Postgres: srv.Config(), Backfill: config.BackfillConfig{BatchSize: 2}}
+	pool, err := db.NewPostgresClient(cfg)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	b, err := NewEmbeddingBackfill(pool, flakyEmbedder{service.NewHashingEmbedder(8)}, checkpoint, zap.NewNop(), cfg)
+	require.NoError(t, err)
+	return b
+}
+
+func TestEmbeddingBackfill_Run(t *testing.T) {
+	srv := newUsersServer(t, map[string]string{
+		"u1": "hiking",
+		"u2": "?!", // no words, nothing to embed
+		"u3": "jazz",
+		"u4": "chess",
+		"u5": "error",
+	}, map[string]bool{"u4": true})
+	checkpoint := &memoryCheckpoint{}
+
+	stats, err := newBackfill(t, srv, checkpoint).Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Scanned: 5, Updated: 2, Skipped: 2, Failed: 1, Batches: 3, LastID: "u5"}, stats)
+	assert.Equal(t, []string{"u2", "u4", "u5"}, checkpoint.saved)
+	assert.True(t, checkpoint.cleared, "a completed run starts over next time")
+	assert.Equal(t, []string{"u1", "u3", "u4"}, updatedIDs(srv))
+}
+
+func TestEmbeddingBackfill_Resume(t *testing.T) {
+	srv := newUsersServer(t, map[string]string{"u1": "hiking", "u2": "jazz", "u3": "chess"}, nil)
+	checkpoint := &memoryCheckpoint{lastID: "u1"}
+
+	stats, err := newBackfill(t, srv, checkpoint).Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Scanned)
+	assert.Equal(t, []string{"u2", "u3"}, updatedIDs(srv))
+}
+
+func TestEmbeddingBackfill_Canceled(t *testing.T) {
+	srv := newUsersServer(t, map[string]string{"u1": "hiking"}, nil)
+	checkpoint := &memoryCheckpoint{lastID: "u0"}
+	ctx, cancel := context.WithCancel(context.Background())
+	b := newBackfill(t, srv, checkpoint)
+	cancel()
+
+	_, err := b.Run(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, "u0", checkpoint.lastID, "the checkpoint must not move past unprocessed users")
+	assert.False(t, checkpoint.cleared)
+}
+
+func TestNewEmbeddingBackfill_InvalidBatchSize(t *testing.T) {
+	_, err := NewEmbeddingBackfill(nil, service.NewHashingEmbedder(8), &memoryCheckpoint{}, zap.NewNop(), &config.Config{})
+	assert.Error(t, err)
+}
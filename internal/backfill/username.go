@@ -0,0 +1,143 @@
+package backfill
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"tx/internal/config"
+	"tx/pkg/utils"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+// UsernameBackfill 用utils.NormalizeUsername为已有用户写入username_normalized
+// 规范形式依赖Go的大小写折叠，无法在SQL中准确计算；每次运行都扫描全部用户并只改写不一致的行，重复运行是安全的
+type UsernameBackfill struct {
+	db     *pgxpool.Pool
+	logger *zap.Logger
+	cfg    config.BackfillConfig
+}
+
+// NewUsernameBackfill 创建用户名回填任务
+func NewUsernameBackfill(db *pgxpool.Pool, logger *zap.Logger, cfg *config.Config) (*UsernameBackfill, error) {
+	if cfg.Backfill.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid backfill batch size %d", cfg.Backfill.BatchSize)
+	}
+	return &UsernameBackfill{db: db, logger: logger.Named("backfill"), cfg: cfg.Backfill}, nil
+}
+
+// storedUsername 用户名和已保存的规范形式
+type storedUsername struct {
+	id         string
+	username   string
+	normalized *string
+}
+
+// Run 写入所有用户的规范形式，完成后检查冲突
+// 规范形式相同的账号无法创建唯一索引，以错误返回，需要人工处理后重新运行
+func (b *UsernameBackfill) Run(ctx context.Context) (Stats, error) {
+	var stats Stats
+	for {
+		users, err := b.nextBatch(ctx, stats.LastID)
+		if err != nil {
+			return stats, err
+		}
+		if len(users) == 0 {
+			break
+		}
+		if err := b.process(ctx, users, &stats); err != nil {
+			return stats, err
+		}
+		stats.Batches++
+		stats.LastID = users[len(users)-1].id
+		if len(users) < b.cfg.BatchSize {
+			break
+		}
+	}
+	b.logger.Info("username backfill completed",
+		zap.Int("scanned", stats.Scanned),
+		zap.Int("updated", stats.Updated),
+		zap.Int("skipped", stats.Skipped))
+
+	conflicts, err := b.conflicts(ctx)
+	if err != nil {
+		return stats, err
+	}
+	if len(conflicts) > 0 {
+		return stats, fmt.Errorf("usernames with the same normalized form must be resolved before adding the unique index: %s",
+			strings.Join(conflicts, "; "))
+	}
+	return stats, nil
+}
+
+// nextBatch 读取lastID之后的一批用户
+func (b *UsernameBackfill) nextBatch(ctx context.Context, lastID string) ([]storedUsername, error) {
+	rows, err := b.db.Query(ctx, `
+		SELECT id, username, username_normalized FROM users
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2`, lastID, b.cfg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (storedUsername, error) {
+		var u storedUsername
+		err := row.Scan(&u.id, &u.username, &u.normalized)
+		return u, err
+	})
+}
+
+// process 改写规范形式缺失或与服务计算结果不一致的用户
+func (b *UsernameBackfill) process(ctx context.Context, users []storedUsername, stats *Stats) error {
+	batch := &pgx.Batch{}
+	for _, u := range users {
+		stats.Scanned++
+		normalized := utils.NormalizeUsername(u.username)
+		if u.normalized != nil && *u.normalized == normalized {
+			stats.Skipped++
+			continue
+		}
+		// 读取之后用户名可能已被UpdateProfile修改，新用户名的规范形式由服务写入
+		batch.Queue("UPDATE users SET username_normalized = $2 WHERE id = $1 AND username = $3",
+			u.id, normalized, u.username)
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	results := b.db.SendBatch(ctx, batch)
+	defer results.Close()
+	for range batch.Len() {
+		tag, err := results.Exec()
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 1 {
+			stats.Updated++
+		} else {
+			stats.Skipped++
+		}
+	}
+	return results.Close()
+}
+
+// conflicts 返回规范形式相同的用户名，每组形如 "alice: Alice, alice"
+func (b *UsernameBackfill) conflicts(ctx context.Context) ([]string, error) {
+	rows, err := b.db.Query(ctx, `
+		SELECT username_normalized, string_agg(username, ', ' ORDER BY username) FROM users
+		WHERE username_normalized IS NOT NULL
+		GROUP BY username_normalized
+		HAVING count(*) > 1
+		ORDER BY username_normalized`)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (string, error) {
+		var normalized, usernames string
+		err := row.Scan(&normalized, &usernames)
+		return normalized + ": " + usernames, err
+	})
+}
@@ -0,0 +1,149 @@
+package backfill
+
+import (
+	"context"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"tx/internal/config"
+	"tx/pkg/db"
+	"tx/pkg/db/dbtest"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// storedUser is a users row as seen by the username backfill.
+type storedUser struct {
+	username   string
+	normalized any // nil for NULL
+}
+
+// newUsernamesServer serves users keyed by ID and applies the backfill's UPDATEs to them.
+func newUsernamesServer(t *testing.T, users map[string]*storedUser) *dbtest.Server {
+	var mu sync.Mutex
+	srv := dbtest.NewServer(t)
+	srv.HandleVector()
+	srv.Handle("SELECT id, username, username_normalized FROM users", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID, pgtype.Int8OID},
+		Columns: []dbtest.Column{
+			{Name: "id", OID: pgtype.TextOID},
+			{Name: "username", OID: pgtype.TextOID},
+			{Name: "username_normalized", OID: pgtype.TextOID},
+		},
+		Rows: func(q dbtest.Query) [][]any {
+			mu.Lock()
+			defer mu.Unlock()
+			lastID, limit := string(q.Params[0]), int(binary.BigEndian.Uint64(q.Params[1]))
+			var ids []string
+			for id := range users {
+				if id > lastID {
+					ids = append(ids, id)
+				}
+			}
+			sort.Strings(ids)
+			var rows [][]any
+			for _, id := range ids[:min(limit, len(ids))] {
+				rows = append(rows, []any{id, users[id].username, users[id].normalized})
+			}
+			return rows
+		},
+	})
+	srv.Handle("UPDATE users SET username_normalized = $2", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID, pgtype.TextOID, pgtype.TextOID},
+		TagFunc: func(q dbtest.Query) string {
+			mu.Lock()
+			defer mu.Unlock()
+			u, ok := users[string(q.Params[0])]
+			if !ok || u.username != string(q.Params[2]) {
+				return "UPDATE 0"
+			}
+			u.normalized = string(q.Params[1])
+			return "UPDATE 1"
+		},
+	})
+	srv.Handle("GROUP BY username_normalized", dbtest.Handler{
+		Columns: []dbtest.Column{{Name: "username_normalized", OID: pgtype.TextOID}, {Name: "string_agg", OID: pgtype.TextOID}},
+		Rows: func(dbtest.Query) [][]any {
+			mu.Lock()
+			defer mu.Unlock()
+			groups := make(map[string][]string)
+			for _, u := range users {
+				if normalized, ok := u.normalized.(string); ok {
+					groups[normalized] = append(groups[normalized], u.username)
+				}
+			}
+			var keys []string
+			for normalized, names := range groups {
+				if len(names) > 1 {
+					keys = append(keys, normalized)
+				}
+			}
+			sort.Strings(keys)
+			var rows [][]any
+			for _, normalized := range keys {
+				names := groups[normalized]
+				sort.Strings(names)
+				rows = append(rows, []any{normalized, strings.Join(names, ", ")})
+			}
+			return rows
+		},
+	})
+	return srv
+}
+
+func newUsernameBackfill(t *testing.T, srv *dbtest.Server) *UsernameBackfill {
+	cfg := &config.Config{Postgres: srv.Config(), Backfill: config.BackfillConfig{BatchSize: 2}}
+	pool, err := db.NewPostgresClient(cfg)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	b, err := NewUsernameBackfill(pool, zap.NewNop(), cfg)
+	require.NoError(t, err)
+	return b
+}
+
+func TestUsernameBackfill_Run(t *testing.T) {
+	users := map[string]*storedUser{
+		"u1": {username: "Alice"},
+		// Written by lower(normalize(..., NFKC)), which does not fold ß like the service does.
+		"u2": {username: "Straße", normalized: "straße"},
+		"u3": {username: "bob", normalized: "bob"},
+		"u4": {username: " Ｃarol "},
+	}
+	srv := newUsernamesServer(t, users)
+
+	stats, err := newUsernameBackfill(t, srv).Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Scanned: 4, Updated: 3, Skipped: 1, Batches: 2, LastID: "u4"}, stats)
+	assert.Equal(t, "alice", users["u1"].normalized)
+	assert.Equal(t, "strasse", users["u2"].normalized)
+	assert.Equal(t, "bob", users["u3"].normalized)
+	assert.Equal(t, "carol", users["u4"].normalized)
+
+	// A second run finds nothing to change.
+	stats, err = newUsernameBackfill(t, srv).Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, stats.Updated)
+}
+
+func TestUsernameBackfill_Conflicts(t *testing.T) {
+	// The SQL approximation kept these apart, the service treats them as one user.
+	srv := newUsernamesServer(t, map[string]*storedUser{
+		"u1": {username: "Straße", normalized: "straße"},
+		"u2": {username: "strasse", normalized: "strasse"},
+	})
+
+	_, err := newUsernameBackfill(t, srv).Run(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "strasse: Straße, strasse")
+}
+
+func TestNewUsernameBackfill_InvalidBatchSize(t *testing.T) {
+	_, err := NewUsernameBackfill(nil, zap.NewNop(), &config.Config{})
+	assert.Error(t, err)
+}
@@ -1,20 +1,28 @@
 package config
 
 import (
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 // Config 应用配置
 type Config struct {
-	GRPC     GRPCConfig     `mapstructure:"grpc"`
-	Postgres PostgresConfig `mapstructure:"postgres"`
-	Redis    RedisConfig    `mapstructure:"redis"`
-	Jaeger   JaegerConfig   `mapstructure:"jaeger"`
+	GRPC         GRPCConfig         `mapstructure:"grpc"`
+	Postgres     PostgresConfig     `mapstructure:"postgres"`
+	Redis        RedisConfig        `mapstructure:"redis"`
+	Jaeger       JaegerConfig       `mapstructure:"jaeger"`
+	System       SystemConfig       `mapstructure:"system"`
+	Auth         AuthConfig         `mapstructure:"auth"`
+	Embedding    EmbeddingConfig    `mapstructure:"embedding"`
+	SimilarUsers SimilarUsersConfig `mapstructure:"similar_users"`
+	Backfill     BackfillConfig     `mapstructure:"backfill"`
 }
 
 // GRPCConfig gRPC服务器配置
 type GRPCConfig struct {
-	Address string `mapstructure:"address"`
+	Address        string `mapstructure:"address"`
+	MaxMessageSize int    `mapstructure:"max_message_size"` // 收发单条消息的最大字节数
 }
 
 // PostgresConfig PostgreSQL配置
@@ -40,6 +48,146 @@ type JaegerConfig struct {
 	ServiceName string `mapstructure:"service_name"`
 }
 
+// SystemConfig 系统服务配置
+type SystemConfig struct {
+	// FileRoots 文件接口允许访问的根目录，请求路径只能解析到这些目录之内
+	FileRoots []string       `mapstructure:"file_roots"`
+	ChunkSize int            `mapstructure:"chunk_size"` // 文件块默认大小（字节）
+	Transfer  TransferConfig `mapstructure:"transfer"`
+	// TailPollInterval TailFile检查文件追加、截断和轮转的间隔
+	TailPollInterval time.Duration `mapstructure:"tail_poll_interval"`
+	// MaxUploadBytes 单个上传文件的大小上限（字节），0表示不限制
+	MaxUploadBytes int64 `mapstructure:"max_upload_bytes"`
+}
+
+// TransferConfig 文件传输限流配置，0表示不限制
+type TransferConfig struct {
+	GlobalBytesPerSecond int64 `mapstructure:"global_bytes_per_second"` // 所有传输共享的带宽上限
+	UserBytesPerSecond   int64 `mapstructure:"user_bytes_per_second"`   // 每个用户的带宽上限
+	MaxStreamsPerUser    int   `mapstructure:"max_streams_per_user"`    // 每个用户同时进行的传输数上限
+}
+
+// AuthConfig 认证配置
+type AuthConfig struct {
+	Username UsernameConfig `mapstructure:"username"`
+	Password PasswordConfig `mapstructure:"password"`
+	JWT      JWTConfig      `mapstructure:"jwt"`
+	// PublicMethods 不需要认证的方法，如登录和注册
+	PublicMethods []string `mapstructure:"public_methods"`
+	// MethodPolicies 方法的访问策略，没有配置的方法只要求调用方已认证
+	MethodPolicies []MethodPolicyConfig `mapstructure:"method_policies"`
+	LoginLimit     LoginLimitConfig     `mapstructure:"login_limit"`
+}
+
+// LoginLimitConfig 登录失败限制，按用户名和客户端IP分别在滑动窗口内统计失败次数
+type LoginLimitConfig struct {
+	Window          time.Duration `mapstructure:"window"`            // 统计失败次数的窗口
+	MaxUserFailures int           `mapstructure:"max_user_failures"` // 同一用户名在窗口内失败次数上限，0表示不锁定
+	MaxIPFailures   int           `mapstructure:"max_ip_failures"`   // 同一IP在窗口内失败次数上限，0表示不锁定
+	LockoutDuration time.Duration `mapstructure:"lockout_duration"`  // 达到上限后的锁定时长
+	FreeAttempts    int           `mapstructure:"free_attempts"`     // 不延迟响应的失败次数
+	BaseDelay       time.Duration `mapstructure:"base_delay"`        // 超过FreeAttempts后的首次延迟，之后每次翻倍
+	MaxDelay        time.Duration `mapstructure:"max_delay"`         // 最长延迟
+}
+
+// MethodPolicyConfig 一个方法的访问策略
+// Method 为 /包名.服务名/方法名，或 /包名.服务名/* 表示整个服务
+// 调用方需要拥有Roles中的任意一个角色和Scopes中的全部权限范围
+type MethodPolicyConfig struct {
+	Method string   `mapstructure:"method"`
+	Roles  []string `mapstructure:"roles"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+// UsernameConfig 用户名规则，长度按规范化（NFKC和大小写折叠）后的字符计算
+type UsernameConfig struct {
+	MinLength      int      `mapstructure:"min_length"`
+	MaxLength      int      `mapstructure:"max_length"`
+	AllowedSymbols string   `mapstructure:"allowed_symbols"` // 字母和数字之外允许的字符，不能出现在开头和结尾
+	Reserved       []string `mapstructure:"reserved"`        // 不允许注册的用户名，不区分大小写
+}
+
+// PasswordConfig 密码哈希配置
+type PasswordConfig struct {
+	// Algorithm 新密码使用的算法（argon2id或bcrypt），其他算法或参数的已有哈希在登录成功后升级
+	Algorithm  string               `mapstructure:"algorithm"`
+	Argon2id   Argon2idConfig       `mapstructure:"argon2id"`
+	BcryptCost int                  `mapstructure:"bcrypt_cost"`
+	Policy     PasswordPolicyConfig `mapstructure:"policy"`
+}
+
+// PasswordPolicyConfig 设置密码时的强度要求，长度按字符计算
+type PasswordPolicyConfig struct {
+	MinLength     int  `mapstructure:"min_length"`
+	MaxLength     int  `mapstructure:"max_length"` // 0表示不限制，bcrypt另有72字节的限制
+	RequireUpper  bool `mapstructure:"require_upper"`
+	RequireLower  bool `mapstructure:"require_lower"`
+	RequireDigit  bool `mapstructure:"require_digit"`
+	RequireSymbol bool `mapstructure:"require_symbol"`
+	// BreachedPasswordsFile 泄露密码列表，每行一个明文密码或SHA-1（HASH或HASH:次数），为空表示不检查
+	BreachedPasswordsFile string `mapstructure:"breached_passwords_file"`
+}
+
+// Argon2idConfig argon2id参数
+type Argon2idConfig struct {
+	Time       uint32 `mapstructure:"time"`        // 迭代次数
+	Memory     uint32 `mapstructure:"memory"`      // 内存（KiB）
+	Threads    uint8  `mapstructure:"threads"`     // 并行度
+	KeyLength  uint32 `mapstructure:"key_length"`  // 哈希长度（字节）
+	SaltLength uint32 `mapstructure:"salt_length"` // 盐长度（字节）
+}
+
+// JWTConfig JWT签发与校验配置
+type JWTConfig struct {
+	Issuer          string        `mapstructure:"issuer"`
+	AccessTokenTTL  time.Duration `mapstructure:"access_token_ttl"`  // 访问令牌有效期，过期后用刷新令牌换取
+	RefreshTokenTTL time.Duration `mapstructure:"refresh_token_ttl"` // 刷新令牌有效期
+	// SigningKey 签发新token使用的密钥kid，其余密钥只用于校验轮换前签发的token
+	SigningKey string         `mapstructure:"signing_key"`
+	Keys       []JWTKeyConfig `mapstructure:"keys"`
+}
+
+// JWTKeyConfig JWT密钥，HS256密钥从环境变量或文件读取，RS256和EdDSA密钥从PEM文件读取
+type JWTKeyConfig struct {
+	KID            string `mapstructure:"kid"`
+	Algorithm      string `mapstructure:"algorithm"`        // HS256、RS256或EdDSA
+	SecretEnv      string `mapstructure:"secret_env"`       // 保存HS256密钥的环境变量
+	SecretFile     string `mapstructure:"secret_file"`      // 保存HS256密钥的文件
+	PrivateKeyFile string `mapstructure:"private_key_file"` // 私钥，公钥由私钥导出
+	PublicKeyFile  string `mapstructure:"public_key_file"`  // 只用于校验的公钥，用于已退役的密钥
+}
+
+// EmbeddingConfig 用户喜好embedding配置
+type EmbeddingConfig struct {
+	// Provider hashing为本地特征哈希，不依赖外部服务；http为调用外部embedding服务
+	Provider   string              `mapstructure:"provider"`
+	Dimensions int                 `mapstructure:"dimensions"` // 必须与users.like_embedding的维度一致
+	HTTP       EmbeddingHTTPConfig `mapstructure:"http"`
+}
+
+// EmbeddingHTTPConfig 外部embedding服务配置
+type EmbeddingHTTPConfig struct {
+	URL       string        `mapstructure:"url"`
+	Model     string        `mapstructure:"model"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+	APIKeyEnv string        `mapstructure:"api_key_env"` // 保存API密钥的环境变量，为空表示不需要认证
+}
+
+// SimilarUsersConfig 相似用户查询配置
+type SimilarUsersConfig struct {
+	// IVFFlatProbes 每次查询扫描的ivfflat列表数，越大召回率越高、查询越慢，0表示使用数据库的设置
+	IVFFlatProbes int `mapstructure:"ivfflat_probes"`
+	DefaultLimit  int `mapstructure:"default_limit"` // 请求未指定数量时每页返回的用户数
+	MaxLimit      int `mapstructure:"max_limit"`     // 每页最多返回的用户数
+}
+
+// BackfillConfig 喜好向量回填任务配置
+type BackfillConfig struct {
+	BatchSize     int           `mapstructure:"batch_size"`     // 每批读取和写入的用户数
+	BatchPause    time.Duration `mapstructure:"batch_pause"`    // 批次之间的暂停，限制对数据库和embedding服务的压力
+	CheckpointKey string        `mapstructure:"checkpoint_key"` // 保存已处理到的用户ID的Redis键
+}
+
 // NewConfig 创建配置
 func NewConfig() (*Config, error) {
 	viper.SetConfigName("config")
@@ -49,9 +197,64 @@ func NewConfig() (*Config, error) {
 
 	// 设置默认值
 	viper.SetDefault("grpc.address", ":50051")
+	viper.SetDefault("grpc.max_message_size", 4*1024*1024)
 	viper.SetDefault("pprof.address", ":6060")
 	viper.SetDefault("postgres.sslmode", "disable")
 	viper.SetDefault("jaeger.service_name", "tx-service")
+	viper.SetDefault("system.file_roots", []string{"./data", "./logs"})
+	viper.SetDefault("system.chunk_size", 1024*1024)
+	viper.SetDefault("system.tail_poll_interval", 500*time.Millisecond)
+	viper.SetDefault("system.max_upload_bytes", 1024*1024*1024)
+	viper.SetDefault("auth.username.min_length", 3)
+	viper.SetDefault("auth.username.max_length", 32)
+	viper.SetDefault("auth.username.allowed_symbols", "._-")
+	viper.SetDefault("auth.username.reserved", []string{
+		"admin", "administrator", "root", "system", "support", "security", "ops", "api", "null", "anonymous",
+	})
+	viper.SetDefault("auth.password.algorithm", "argon2id")
+	viper.SetDefault("auth.password.argon2id.time", 2)
+	viper.SetDefault("auth.password.argon2id.memory", 19*1024)
+	viper.SetDefault("auth.password.argon2id.threads", 1)
+	viper.SetDefault("auth.password.argon2id.key_length", 32)
+	viper.SetDefault("auth.password.argon2id.salt_length", 16)
+	viper.SetDefault("auth.password.bcrypt_cost", 12)
+	viper.SetDefault("auth.password.policy.min_length", 8)
+	viper.SetDefault("auth.password.policy.max_length", 128)
+	viper.SetDefault("auth.jwt.issuer", "tx")
+	viper.SetDefault("auth.jwt.access_token_ttl", 15*time.Minute)
+	viper.SetDefault("auth.jwt.refresh_token_ttl", 30*24*time.Hour)
+	viper.SetDefault("auth.jwt.signing_key", "default")
+	viper.SetDefault("auth.jwt.keys", []map[string]any{
+		{"kid": "default", "algorithm": "HS256", "secret_env": "TX_JWT_SECRET"},
+	})
+	viper.SetDefault("auth.login_limit.window", 15*time.Minute)
+	viper.SetDefault("auth.login_limit.max_user_failures", 5)
+	viper.SetDefault("auth.login_limit.max_ip_failures", 50)
+	viper.SetDefault("auth.login_limit.lockout_duration", 15*time.Minute)
+	viper.SetDefault("auth.login_limit.free_attempts", 2)
+	viper.SetDefault("auth.login_limit.base_delay", 500*time.Millisecond)
+	viper.SetDefault("auth.login_limit.max_delay", 4*time.Second)
+	viper.SetDefault("auth.public_methods", []string{
+		"/user.UserService/Register",
+		"/user.UserService/Login",
+		"/user.UserService/GetJWKS",
+		// 访问令牌过期后才需要刷新，因此不能要求访问令牌
+		"/user.UserService/RefreshToken",
+	})
+	// 文件服务可以读取、覆盖服务根目录下的任意文件，默认只允许运维和管理员访问
+	viper.SetDefault("auth.method_policies", []map[string]any{
+		{"method": "/system.SystemService/*", "roles": []string{"ops", "admin"}},
+	})
+
+	viper.SetDefault("embedding.provider", "hashing")
+	viper.SetDefault("embedding.dimensions", 384)
+	viper.SetDefault("embedding.http.timeout", 5*time.Second)
+	viper.SetDefault("similar_users.ivfflat_probes", 10)
+	viper.SetDefault("similar_users.default_limit", 10)
+	viper.SetDefault("similar_users.max_limit", 100)
+	viper.SetDefault("backfill.batch_size", 200)
+	viper.SetDefault("backfill.batch_pause", 100*time.Millisecond)
+	viper.SetDefault("backfill.checkpoint_key", "backfill:like_embedding:last_id")
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, err
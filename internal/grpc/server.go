@@ -3,8 +3,11 @@ package grpc
 import (
 	pb "tx/proto/gen"
 
+	"tx/internal/config"
 	"tx/internal/interceptor"
 	"tx/internal/service"
+	"tx/internal/session"
+	"tx/pkg/utils"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
@@ -16,13 +19,16 @@ type Server struct {
 }
 
 // NewGRPCServer 创建并配置gRPC服务器
-func NewGRPCServer(userSvc *service.UserService, systemSvc *service.SystemService, logger *zap.Logger) *Server {
+func NewGRPCServer(userSvc *service.UserService, systemSvc *service.SystemService, logger *zap.Logger, cfg *config.Config, jwt *utils.JWTManager, sessions *session.Store, policies *interceptor.PolicyTable) *Server {
 	// 创建拦截器
-	authInterceptor := interceptor.NewAuthInterceptor(logger)
+	authInterceptor := interceptor.NewAuthInterceptor(logger, jwt, sessions, policies)
 	tracerInterceptor := interceptor.NewTracerInterceptor(logger)
 
 	// 创建gRPC服务器，注册所有拦截器
 	grpcServer := grpc.NewServer(
+		// 文件块大小受最大消息大小限制
+		grpc.MaxRecvMsgSize(cfg.GRPC.MaxMessageSize),
+		grpc.MaxSendMsgSize(cfg.GRPC.MaxMessageSize),
 		grpc.ChainUnaryInterceptor(
 			tracerInterceptor.Unary(),
 			authInterceptor.Unary(),
@@ -3,6 +3,7 @@ package interceptor
 import (
 	"context"
 
+	"tx/internal/session"
 	"tx/pkg/utils"
 
 	"go.uber.org/zap"
@@ -20,13 +21,19 @@ const UserIDKey contextKey = "user_id"
 
 // AuthInterceptor 实现认证拦截器
 type AuthInterceptor struct {
-	logger *zap.Logger
+	logger   *zap.Logger
+	jwt      *utils.JWTManager
+	sessions *session.Store
+	policies *PolicyTable
 }
 
 // NewAuthInterceptor 创建认证拦截器
-func NewAuthInterceptor(logger *zap.Logger) *AuthInterceptor {
+func NewAuthInterceptor(logger *zap.Logger, jwt *utils.JWTManager, sessions *session.Store, policies *PolicyTable) *AuthInterceptor {
 	return &AuthInterceptor{
-		logger: logger,
+		logger:   logger,
+		jwt:      jwt,
+		sessions: sessions,
+		policies: policies,
 	}
 }
 
@@ -39,19 +46,18 @@ func (i *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 		handler grpc.UnaryHandler,
 	) (any, error) {
 		// 跳过不需要认证的方法，如登录和注册
-		if isPublicMethod(info.FullMethod) {
+		if i.policies.IsPublic(info.FullMethod) {
 			return handler(ctx, req)
 		}
 
-		// 执行认证
-		userID, err := i.authenticate(ctx)
+		// 执行认证和授权
+		principal, err := i.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
 
-		// 将用户ID添加到上下文
-		newCtx := context.WithValue(ctx, UserIDKey, userID)
-		return handler(newCtx, req)
+		// 将调用方和用户ID添加到上下文
+		return handler(ContextWithPrincipal(ctx, principal), req)
 	}
 }
 
@@ -63,62 +69,78 @@ func (i *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		if isPublicMethod(info.FullMethod) {
+		if i.policies.IsPublic(info.FullMethod) {
 			return handler(srv, ss)
 		}
 
-		// 执行认证
-		userID, err := i.authenticate(ss.Context())
+		// 执行认证和授权
+		principal, err := i.authorize(ss.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
 
-		// 包装流，将用户ID添加到上下文
+		// 包装流，将调用方和用户ID添加到上下文
 		wrappedStream := &wrappedServerStream{
 			ServerStream: ss,
-			ctx:          context.WithValue(ss.Context(), UserIDKey, userID),
+			ctx:          ContextWithPrincipal(ss.Context(), principal),
 		}
 		return handler(srv, wrappedStream)
 	}
 }
 
-// authenticate 验证JWT并提取用户ID
-func (i *AuthInterceptor) authenticate(ctx context.Context) (string, error) {
+// authorize 认证调用方并检查方法的访问策略
+func (i *AuthInterceptor) authorize(ctx context.Context, method string) (*Principal, error) {
+	claims, err := i.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	principal := newPrincipal(claims)
+	if err := i.policies.Authorize(method, principal); err != nil {
+		i.logger.Warn("Permission denied",
+			zap.String("method", method),
+			zap.String("user_id", principal.UserID),
+			zap.Strings("roles", principal.Roles))
+		return nil, err
+	}
+	return principal, nil
+}
+
+// authenticate 验证JWT并检查是否已被吊销
+func (i *AuthInterceptor) authenticate(ctx context.Context) (*utils.Claims, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return "", status.Errorf(codes.Unauthenticated, "metadata is not provided")
+		return nil, status.Errorf(codes.Unauthenticated, "metadata is not provided")
 	}
 
 	authHeader := md["authorization"]
 	if len(authHeader) == 0 {
-		return "", status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+		return nil, status.Errorf(codes.Unauthenticated, "authorization token is not provided")
 	}
 
 	tokenStr := authHeader[0]
 	if len(tokenStr) > 7 && tokenStr[:7] == "Bearer " {
 		tokenStr = tokenStr[7:]
 	}
-	claims, err := utils.ParseToken(tokenStr)
+	claims, err := i.jwt.ParseToken(tokenStr)
 	if err != nil {
-		return "", status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
 	}
 	// 获取用户ID
-	userID := claims.UserId
-	if userID == "" {
-		return "", status.Errorf(codes.Unauthenticated, "invalid token payload")
+	if claims.UserId == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token payload")
 	}
 
-	return userID, nil
-}
-
-// isPublicMethod 检查是否为公开方法（不需要认证）
-func isPublicMethod(method string) bool {
-	// 根据实际需求配置公开方法
-	publicMethods := map[string]bool{
-		"/user.UserService/Register": true,
-		"/user.UserService/Login":    true,
+	// 退出登录或修改密码后令牌立即失效；无法确认时拒绝请求
+	revoked, err := i.sessions.IsRevoked(ctx, claims)
+	if err != nil {
+		i.logger.Error("Failed to check token revocation", zap.String("user_id", claims.UserId), zap.Error(err))
+		return nil, status.Errorf(codes.Unavailable, "failed to check token revocation")
 	}
-	return publicMethods[method]
+	if revoked {
+		return nil, status.Errorf(codes.Unauthenticated, "token has been revoked")
+	}
+
+	return claims, nil
 }
 
 // wrappedServerStream 包装grpc.ServerStream，使其使用自定义上下文
@@ -0,0 +1,101 @@
+package interceptor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+	"tx/internal/session"
+	"tx/pkg/db"
+	"tx/pkg/db/redistest"
+	"tx/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthInterceptor_Revocation(t *testing.T) {
+	t.Setenv("TEST_JWT_SECRET", strings.Repeat("s", 32))
+	cfg := &config.Config{Auth: config.AuthConfig{JWT: config.JWTConfig{
+		Issuer:          "tx",
+		AccessTokenTTL:  time.Hour,
+		RefreshTokenTTL: 24 * time.Hour,
+		SigningKey:      "hs",
+		Keys:            []config.JWTKeyConfig{{KID: "hs", Algorithm: utils.JWTAlgorithmHS256, SecretEnv: "TEST_JWT_SECRET"}},
+	}}}
+	jwtManager, err := utils.NewJWTManager(cfg)
+	require.NoError(t, err)
+	srv := redistest.NewServer(t)
+	client, err := db.NewRedisClient(&config.Config{Redis: srv.Config()})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	sessions := session.NewStore(client, cfg)
+	policies, err := NewPolicyTable(policyConfig(nil))
+	require.NoError(t, err)
+	unary := NewAuthInterceptor(zap.NewNop(), jwtManager, sessions, policies).Unary()
+
+	call := func(token string) (*Principal, error) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+		var principal *Principal
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUserInfo"},
+			func(ctx context.Context, req any) (any, error) {
+				principal, _ = PrincipalFromContext(ctx)
+				return nil, nil
+			})
+		return principal, err
+	}
+	issue := func(userID string) (string, *utils.Claims) {
+		token, err := jwtManager.GenerateToken(userID, "alice", nil, nil)
+		require.NoError(t, err)
+		claims, err := jwtManager.ParseToken(token)
+		require.NoError(t, err)
+		return token, claims
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		token, _ := issue("user-1")
+		principal, err := call(token)
+		require.NoError(t, err)
+		require.NotNil(t, principal)
+		assert.Equal(t, "user-1", principal.UserID)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		_, err := call("not-a-jwt")
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("RevokedToken", func(t *testing.T) {
+		token, claims := issue("user-2")
+		other, _ := issue("user-2")
+		require.NoError(t, sessions.RevokeAccessToken(context.Background(), claims))
+
+		_, err := call(token)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = call(other)
+		assert.NoError(t, err, "Only the logged out token is revoked")
+	})
+
+	t.Run("RevokedUser", func(t *testing.T) {
+		token, _ := issue("user-3")
+		require.NoError(t, sessions.RevokeUser(context.Background(), "user-3"))
+
+		_, err := call(token)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("RedisUnavailable", func(t *testing.T) {
+		token, _ := issue("user-4")
+		srv.Close()
+		// A token whose revocation state is unknown is rejected.
+		_, err := call(token)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	})
+}
@@ -0,0 +1,109 @@
+package interceptor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"tx/internal/config"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RoleAdmin 管理员角色
+const RoleAdmin = "admin"
+
+// Policy 调用一个方法需要满足的条件
+type Policy struct {
+	Roles  []string // 拥有其中任意一个角色即可，为空表示不限制
+	Scopes []string // 必须拥有全部权限范围
+}
+
+// PolicyTable 每个RPC方法的访问策略
+// 方法名为 /包名.服务名/方法名，也可以用 /包名.服务名/* 为整个服务设置策略，方法自己的策略优先
+// 没有配置策略的方法只要求调用方已认证
+type PolicyTable struct {
+	public   map[string]bool
+	methods  map[string]Policy
+	services map[string]Policy
+}
+
+// NewPolicyTable 根据配置创建访问策略表
+func NewPolicyTable(cfg *config.Config) (*PolicyTable, error) {
+	t := &PolicyTable{
+		public:   make(map[string]bool),
+		methods:  make(map[string]Policy),
+		services: make(map[string]Policy),
+	}
+	for _, method := range cfg.Auth.PublicMethods {
+		if _, _, err := splitMethod(method); err != nil {
+			return nil, err
+		}
+		t.public[method] = true
+	}
+	for _, pc := range cfg.Auth.MethodPolicies {
+		service, name, err := splitMethod(pc.Method)
+		if err != nil {
+			return nil, err
+		}
+		if t.public[pc.Method] {
+			return nil, fmt.Errorf("method %q is both public and restricted", pc.Method)
+		}
+		policy := Policy{Roles: pc.Roles, Scopes: pc.Scopes}
+		target := t.methods
+		key := pc.Method
+		if name == "*" {
+			target, key = t.services, service
+		}
+		if _, ok := target[key]; ok {
+			return nil, fmt.Errorf("duplicate policy for %q", pc.Method)
+		}
+		target[key] = policy
+	}
+	return t, nil
+}
+
+// splitMethod 将 /包名.服务名/方法名 拆分为服务和方法
+func splitMethod(method string) (service, name string, err error) {
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if !strings.HasPrefix(method, "/") || !ok || service == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("invalid method name %q, expected /package.Service/Method", method)
+	}
+	return service, name, nil
+}
+
+// IsPublic 判断方法是否不需要认证
+func (t *PolicyTable) IsPublic(method string) bool {
+	return t.public[method]
+}
+
+// policy 返回方法适用的策略
+func (t *PolicyTable) policy(method string) (Policy, bool) {
+	if p, ok := t.methods[method]; ok {
+		return p, true
+	}
+	service, _, err := splitMethod(method)
+	if err != nil {
+		return Policy{}, false
+	}
+	p, ok := t.services[service]
+	return p, ok
+}
+
+// Authorize 检查调用方是否满足方法的访问策略，不满足时返回PermissionDenied
+func (t *PolicyTable) Authorize(method string, p *Principal) error {
+	policy, ok := t.policy(method)
+	if !ok {
+		return nil
+	}
+	if len(policy.Roles) > 0 && !slices.ContainsFunc(policy.Roles, p.HasRole) {
+		return status.Errorf(codes.PermissionDenied, "%s requires one of roles %v", method, policy.Roles)
+	}
+	for _, scope := range policy.Scopes {
+		if !p.HasScope(scope) {
+			return status.Errorf(codes.PermissionDenied, "%s requires scope %q", method, scope)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package interceptor
+
+import (
+	"testing"
+
+	"tx/internal/config"
+	pb "tx/proto/gen"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func policyConfig(public []string, policies ...config.MethodPolicyConfig) *config.Config {
+	return &config.Config{Auth: config.AuthConfig{PublicMethods: public, MethodPolicies: policies}}
+}
+
+func TestPolicyTable(t *testing.T) {
+	table, err := NewPolicyTable(policyConfig(
+		[]string{"/user.UserService/Login"},
+		config.MethodPolicyConfig{Method: "/system.SystemService/SendFile", Roles: []string{"ops", "admin"}},
+		config.MethodPolicyConfig{Method: "/system.SystemService/*", Scopes: []string{"system:read"}},
+		config.MethodPolicyConfig{Method: "/system.SystemService/TailFile", Roles: []string{"ops"}, Scopes: []string{"system:read", "logs:read"}},
+	))
+	require.NoError(t, err)
+
+	assert.True(t, table.IsPublic("/user.UserService/Login"))
+	assert.False(t, table.IsPublic("/user.UserService/GetUserInfo"))
+	assert.False(t, table.IsPublic("/system.SystemService/SendFile"))
+
+	tests := []struct {
+		name    string
+		method  string
+		roles   []string
+		scopes  []string
+		allowed bool
+	}{
+		{"NoPolicy", "/user.UserService/GetUserInfo", nil, nil, true},
+		{"AnyRole", "/system.SystemService/SendFile", []string{"admin"}, nil, true},
+		{"MissingRole", "/system.SystemService/SendFile", []string{"user"}, nil, false},
+		{"MethodOverridesService", "/system.SystemService/SendFile", []string{"ops"}, nil, true},
+		{"ServiceWildcard", "/system.SystemService/ListFiles", nil, []string{"system:read"}, true},
+		{"ServiceWildcardMissingScope", "/system.SystemService/ListFiles", []string{"admin"}, nil, false},
+		{"AllScopes", "/system.SystemService/TailFile", []string{"ops"}, []string{"logs:read", "system:read"}, true},
+		{"MissingOneScope", "/system.SystemService/TailFile", []string{"ops"}, []string{"system:read"}, false},
+		{"ScopesWithoutRole", "/system.SystemService/TailFile", nil, []string{"logs:read", "system:read"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := table.Authorize(tt.method, &Principal{UserID: "1234567890", Roles: tt.roles, Scopes: tt.scopes})
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			}
+		})
+	}
+}
+
+func TestPolicyTable_SystemServiceRequiresRole(t *testing.T) {
+	// Every file RPC can read or overwrite files under the served roots, so the
+	// shipped configuration must restrict all of them, not only SendFile.
+	t.Chdir("../..")
+	cfg, err := config.NewConfig()
+	require.NoError(t, err)
+	table, err := NewPolicyTable(cfg)
+	require.NoError(t, err)
+
+	var methods []string
+	for _, m := range pb.SystemService_ServiceDesc.Methods {
+		methods = append(methods, m.MethodName)
+	}
+	for _, s := range pb.SystemService_ServiceDesc.Streams {
+		methods = append(methods, s.StreamName)
+	}
+	require.NotEmpty(t, methods)
+
+	for _, name := range methods {
+		method := "/" + pb.SystemService_ServiceDesc.ServiceName + "/" + name
+		t.Run(name, func(t *testing.T) {
+			assert.False(t, table.IsPublic(method))
+			err := table.Authorize(method, &Principal{UserID: "1234567890"})
+			assert.Equal(t, codes.PermissionDenied, status.Code(err), "plain users must not call %s", method)
+			err = table.Authorize(method, &Principal{UserID: "1234567890", Roles: []string{"user", "reader"}})
+			assert.Equal(t, codes.PermissionDenied, status.Code(err))
+			assert.NoError(t, table.Authorize(method, &Principal{UserID: "1234567890", Roles: []string{"ops"}}))
+			assert.NoError(t, table.Authorize(method, &Principal{UserID: "1234567890", Roles: []string{RoleAdmin}}))
+		})
+	}
+	// User RPCs stay available to any authenticated caller.
+	assert.NoError(t, table.Authorize("/user.UserService/GetUserInfo", &Principal{UserID: "1234567890"}))
+}
+
+func TestNewPolicyTable_InvalidConfig(t *testing.T) {
+	invalid := []*config.Config{
+		policyConfig([]string{"user.UserService/Login"}),
+		policyConfig([]string{"/user.UserService"}),
+		policyConfig(nil, config.MethodPolicyConfig{Method: "/user.UserService/Get/Info"}),
+		policyConfig(nil, config.MethodPolicyConfig{Method: "//SendFile"}),
+		policyConfig([]string{"/user.UserService/Login"}, config.MethodPolicyConfig{Method: "/user.UserService/Login", Roles: []string{"admin"}}),
+		policyConfig(nil,
+			config.MethodPolicyConfig{Method: "/system.SystemService/*", Roles: []string{"ops"}},
+			config.MethodPolicyConfig{Method: "/system.SystemService/*", Roles: []string{"admin"}}),
+	}
+	for _, cfg := range invalid {
+		_, err := NewPolicyTable(cfg)
+		assert.Error(t, err, "%+v", cfg.Auth)
+	}
+}
@@ -0,0 +1,53 @@
+package interceptor
+
+import (
+	"context"
+	"slices"
+
+	"tx/pkg/utils"
+)
+
+// PrincipalKey 是已认证调用方在上下文中的键
+const PrincipalKey contextKey = "principal"
+
+// Principal 通过访问令牌认证的调用方
+type Principal struct {
+	UserID   string        // 注册时生成的用户ID
+	Username string        // 签发令牌时的用户名
+	Roles    []string      // 签发令牌时的角色
+	Scopes   []string      // 签发令牌时的权限范围
+	Claims   *utils.Claims // 访问令牌的原始声明，用于吊销等操作
+}
+
+// newPrincipal 从访问令牌声明构造调用方
+func newPrincipal(claims *utils.Claims) *Principal {
+	return &Principal{
+		UserID:   claims.UserId,
+		Username: claims.Username,
+		Roles:    claims.Roles,
+		Scopes:   claims.Scopes,
+		Claims:   claims,
+	}
+}
+
+// HasRole 判断调用方是否拥有角色
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// HasScope 判断调用方是否拥有权限范围
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// PrincipalFromContext 获取当前请求的已认证调用方，公开方法的请求中不存在
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(PrincipalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// ContextWithPrincipal 将调用方添加到上下文，同时设置UserIDKey
+func ContextWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	ctx = context.WithValue(ctx, UserIDKey, p.UserID)
+	return context.WithValue(ctx, PrincipalKey, p)
+}
@@ -0,0 +1,30 @@
+package interceptor
+
+import (
+	"context"
+	"testing"
+
+	"tx/pkg/utils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipalFromContext(t *testing.T) {
+	_, ok := PrincipalFromContext(context.Background())
+	assert.False(t, ok)
+
+	claims := &utils.Claims{UserId: "1234567890", Username: "alice", Roles: []string{"admin"}, Scopes: []string{"system:read"}}
+	ctx := ContextWithPrincipal(context.Background(), newPrincipal(claims))
+
+	p, ok := PrincipalFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, "1234567890", p.UserID)
+	assert.Equal(t, "alice", p.Username)
+	assert.Same(t, claims, p.Claims)
+	assert.True(t, p.HasRole("admin"))
+	assert.False(t, p.HasRole("ops"))
+	assert.True(t, p.HasScope("system:read"))
+	assert.False(t, p.HasScope("system:write"))
+	assert.Equal(t, "1234567890", ctx.Value(UserIDKey), "UserIDKey must carry the user ID, not the username")
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// chunkBuffers 文件块缓冲区池，所有文件传输共享
+var chunkBuffers bufferPool
+
+// bufferPool 按2的幂容量分级复用字节缓冲区
+// 第k级中的缓冲区容量至少为2^k，取用时从能容纳所需大小的最小级别获取
+type bufferPool struct {
+	classes [bits.UintSize]sync.Pool
+}
+
+// get 返回长度为size的缓冲区，使用完后应通过put归还
+func (p *bufferPool) get(size int) *[]byte {
+	class := 0
+	if size > 1 {
+		class = bits.Len(uint(size - 1))
+	}
+	if b, ok := p.classes[class].Get().(*[]byte); ok {
+		*b = (*b)[:size]
+		return b
+	}
+	b := make([]byte, size, 1<<class)
+	return &b
+}
+
+// put 归还缓冲区，按容量向下取整放入对应级别
+func (p *bufferPool) put(b *[]byte) {
+	if b == nil || cap(*b) == 0 {
+		return
+	}
+	class := bits.Len(uint(cap(*b))) - 1
+	*b = (*b)[:0]
+	p.classes[class].Put(b)
+}
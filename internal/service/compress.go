@@ -0,0 +1,99 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	pb "tx/proto/gen"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// gzipWriterPool 复用gzip压缩器，避免每个文件块重新分配
+	gzipWriterPool = sync.Pool{
+		New: func() any { return gzip.NewWriter(nil) },
+	}
+
+	// zstd压缩器的EncodeAll可以并发调用，全局共享一个实例
+	zstdEncoder, _ = zstd.NewWriter(nil)
+)
+
+// negotiateCompression 按客户端给出的优先级选择第一个服务端支持的压缩算法
+func negotiateCompression(accepted []pb.Compression) pb.Compression {
+	for _, c := range accepted {
+		switch c {
+		case pb.Compression_COMPRESSION_GZIP, pb.Compression_COMPRESSION_ZSTD:
+			return c
+		}
+	}
+	return pb.Compression_COMPRESSION_NONE
+}
+
+// compressChunk 使用指定算法压缩文件块，结果写入dst复用其底层数组
+// 压缩后没有变小时返回原始内容和COMPRESSION_NONE，因此每个块实际使用的算法可能不同
+func compressChunk(c pb.Compression, dst, src []byte) ([]byte, pb.Compression, error) {
+	var out []byte
+	switch c {
+	case pb.Compression_COMPRESSION_GZIP:
+		buf := bytes.NewBuffer(dst[:0])
+		zw := gzipWriterPool.Get().(*gzip.Writer)
+		defer gzipWriterPool.Put(zw)
+		zw.Reset(buf)
+		if _, err := zw.Write(src); err != nil {
+			return nil, c, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, c, err
+		}
+		out = buf.Bytes()
+	case pb.Compression_COMPRESSION_ZSTD:
+		out = zstdEncoder.EncodeAll(src, dst[:0])
+	default:
+		return src, pb.Compression_COMPRESSION_NONE, nil
+	}
+	if len(out) >= len(src) {
+		return src, pb.Compression_COMPRESSION_NONE, nil
+	}
+	return out, c, nil
+}
+
+// errChunkTooLarge 解压后的文件块超过允许的字节数
+var errChunkTooLarge = errors.New("chunk content exceeds the remaining size")
+
+// decompressChunk 解压文件块，内容超过limit字节时返回errChunkTooLarge，用于限制上传大小并防止压缩炸弹
+func decompressChunk(c pb.Compression, src []byte, limit int64) ([]byte, error) {
+	var out []byte
+	switch c {
+	case pb.Compression_COMPRESSION_NONE:
+		out = src
+	case pb.Compression_COMPRESSION_GZIP:
+		zr, err := gzip.NewReader(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		if out, err = io.ReadAll(io.LimitReader(zr, limit+1)); err != nil {
+			return nil, err
+		}
+	case pb.Compression_COMPRESSION_ZSTD:
+		frame, err := zstd.NewReader(bytes.NewReader(src), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer frame.Close()
+		if out, err = io.ReadAll(io.LimitReader(frame, limit+1)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %v", c)
+	}
+	if int64(len(out)) > limit {
+		return nil, fmt.Errorf("%w of %d bytes", errChunkTooLarge, limit)
+	}
+	return out, nil
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxCachedDigests 缓存的文件摘要数量上限
+const maxCachedDigests = 1024
+
+// digestCache 按路径缓存整个文件的SHA-256摘要，续传和区间请求不必每次重新读取整个文件
+// 大小或修改时间与缓存时不同即视为文件已修改并重新计算，超过上限时淘汰最久未使用的路径
+type digestCache struct {
+	mu      sync.Mutex
+	limit   int
+	entries map[string]*list.Element
+	order   *list.List // 最近使用的在前
+}
+
+// digestEntry 一个文件的摘要以及计算时的大小和修改时间
+type digestEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// newDigestCache 创建最多缓存limit个文件摘要的缓存
+func newDigestCache(limit int) *digestCache {
+	return &digestCache{limit: limit, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// fileDigest 返回文件的十六进制SHA-256摘要，没有缓存或文件已修改时读取整个文件计算
+// buffer用作读取文件的临时缓冲区，计算过程可以通过ctx取消
+func (c *digestCache) fileDigest(ctx context.Context, file *os.File, path string, info os.FileInfo, buffer []byte) (string, error) {
+	if sum, ok := c.get(path, info); ok {
+		return sum, nil
+	}
+	digest := sha256.New()
+	reader := &contextReader{ctx: ctx, r: io.NewSectionReader(file, 0, info.Size())}
+	if _, err := io.CopyBuffer(digest, reader, buffer); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(digest.Sum(nil))
+	c.put(&digestEntry{path: path, size: info.Size(), modTime: info.ModTime(), sum: sum})
+	return sum, nil
+}
+
+// get 返回与文件当前大小和修改时间一致的缓存摘要
+func (c *digestCache) get(path string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[path]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*digestEntry)
+	if entry.size != info.Size() || !entry.modTime.Equal(info.ModTime()) {
+		return "", false
+	}
+	c.order.MoveToFront(elem)
+	return entry.sum, true
+}
+
+// put 保存摘要，替换同一路径的旧摘要
+func (c *digestCache) put(entry *digestEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[entry.path]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.path] = c.order.PushFront(entry)
+	for c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*digestEntry).path)
+	}
+}
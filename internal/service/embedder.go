@@ -0,0 +1,210 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+
+	"tx/internal/config"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// 支持的embedding实现
+const (
+	EmbeddingProviderHashing = "hashing"
+	EmbeddingProviderHTTP    = "http"
+)
+
+// maxEmbeddingResponseSize embedding服务响应的最大字节数
+const maxEmbeddingResponseSize = 4 << 20
+
+// Embedder 将用户喜好文本转换为固定维度的向量，用于按余弦距离查找相似用户
+type Embedder interface {
+	// Embed 返回文本的向量，文本不包含任何内容时返回nil
+	Embed(ctx context.Context, text string) ([]float32, error)
+	// Dimensions 向量维度，必须与users.like_embedding的维度一致
+	Dimensions() int
+}
+
+// NewEmbedder 根据配置创建embedding实现
+func NewEmbedder(cfg *config.Config) (Embedder, error) {
+	ec := cfg.Embedding
+	if ec.Dimensions <= 0 {
+		return nil, fmt.Errorf("invalid embedding dimensions %d", ec.Dimensions)
+	}
+	switch ec.Provider {
+	case EmbeddingProviderHashing:
+		return NewHashingEmbedder(ec.Dimensions), nil
+	case EmbeddingProviderHTTP:
+		return NewHTTPEmbedder(ec.Dimensions, ec.HTTP)
+	default:
+		return nil, fmt.Errorf("unsupported embedding provider %q", ec.Provider)
+	}
+}
+
+// HashingEmbedder 基于特征哈希的本地embedding，不依赖外部服务，相同输入总是得到相同向量
+// 特征为单词和带边界标记的字符二元组、三元组，使中文等不以空格分词的文本和拼写相近的词也能匹配
+type HashingEmbedder struct {
+	dims int
+}
+
+// NewHashingEmbedder 创建特征哈希embedding
+func NewHashingEmbedder(dims int) *HashingEmbedder {
+	return &HashingEmbedder{dims: dims}
+}
+
+// Dimensions 向量维度
+func (e *HashingEmbedder) Dimensions() int {
+	return e.dims
+}
+
+// Embed 将每个特征哈希到一个维度并按哈希的另一位决定符号，最后做L2归一化
+func (e *HashingEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	vec := make([]float64, e.dims)
+	empty := true
+	for _, word := range embeddingTokens(text) {
+		empty = false
+		e.add(vec, "w:"+word, 1)
+		runes := []rune("^" + word + "$")
+		for n := 2; n <= 3; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				e.add(vec, "c:"+string(runes[i:i+n]), 0.5)
+			}
+		}
+	}
+	if empty {
+		return nil, nil
+	}
+	return normalizeVector(vec), nil
+}
+
+// add 将一个特征累加到向量
+func (e *HashingEmbedder) add(vec []float64, feature string, weight float64) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+	index := sum % uint64(e.dims)
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	vec[index] += weight
+}
+
+// embeddingTokens 规范化文本后按字母和数字以外的字符切分
+func embeddingTokens(text string) []string {
+	text = norm.NFKC.String(cases.Fold().String(text))
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+	})
+}
+
+// normalizeVector L2归一化，零向量返回nil
+func normalizeVector(vec []float64) []float32 {
+	var sum float64
+	for _, v := range vec {
+		sum += v * v
+	}
+	if sum == 0 {
+		return nil
+	}
+	length := math.Sqrt(sum)
+	result := make([]float32, len(vec))
+	for i, v := range vec {
+		result[i] = float32(v / length)
+	}
+	return result
+}
+
+// HTTPEmbedder 调用外部embedding服务
+// 请求体为 {"model": ..., "input": ...}，响应可以是 {"embedding": [...]} 或OpenAI格式的 {"data": [{"embedding": [...]}]}
+type HTTPEmbedder struct {
+	dims   int
+	url    string
+	model  string
+	apiKey string
+	client *http.Client
+}
+
+// NewHTTPEmbedder 创建调用外部服务的embedding
+func NewHTTPEmbedder(dims int, hc config.EmbeddingHTTPConfig) (*HTTPEmbedder, error) {
+	if hc.URL == "" {
+		return nil, errors.New("http embedding provider requires url")
+	}
+	e := &HTTPEmbedder{
+		dims:   dims,
+		url:    hc.URL,
+		model:  hc.Model,
+		client: &http.Client{Timeout: hc.Timeout},
+	}
+	if hc.APIKeyEnv != "" {
+		if e.apiKey = os.Getenv(hc.APIKeyEnv); e.apiKey == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", hc.APIKeyEnv)
+		}
+	}
+	return e, nil
+}
+
+// Dimensions 向量维度
+func (e *HTTPEmbedder) Dimensions() int {
+	return e.dims
+}
+
+// Embed 请求外部服务，返回的维度与配置不一致时报错
+func (e *HTTPEmbedder) Embed(ctx context.Context, text string) ([]float32, error) {
+	if len(embeddingTokens(text)) == 0 {
+		return nil, nil
+	}
+	body, err := json.Marshal(map[string]string{"model": e.model, "input": text})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.apiKey)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxEmbeddingResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding service returned %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	var result struct {
+		Embedding []float32 `json:"embedding"`
+		Data      []struct {
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("decode embedding response: %w", err)
+	}
+	vec := result.Embedding
+	if vec == nil && len(result.Data) > 0 {
+		vec = result.Data[0].Embedding
+	}
+	if len(vec) != e.dims {
+		return nil, fmt.Errorf("embedding service returned %d dimensions, expected %d", len(vec), e.dims)
+	}
+	return vec, nil
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cosine returns the cosine similarity of two vectors.
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+func TestHashingEmbedder(t *testing.T) {
+	ctx := context.Background()
+	e := NewHashingEmbedder(384)
+	embed := func(text string) []float32 {
+		t.Helper()
+		vec, err := e.Embed(ctx, text)
+		require.NoError(t, err)
+		return vec
+	}
+
+	hiking := embed("hiking, mountains and photography")
+	require.Len(t, hiking, 384)
+	assert.Equal(t, hiking, embed("hiking, mountains and photography"), "Embeddings must be deterministic")
+	assert.Equal(t, hiking, embed("  HIKING mountains AND Photography!"), "Case and punctuation must not matter")
+
+	var length float64
+	for _, v := range hiking {
+		length += float64(v) * float64(v)
+	}
+	assert.InDelta(t, 1, length, 1e-5, "Vectors are L2-normalized")
+
+	similar := embed("mountain hiking, landscape photography")
+	unrelated := embed("jazz piano and cooking")
+	assert.Greater(t, cosine(hiking, similar), cosine(hiking, unrelated))
+	assert.Greater(t, cosine(embed("喜欢爬山和摄影"), embed("爬山")), cosine(embed("喜欢爬山和摄影"), embed("做饭")))
+
+	assert.Nil(t, embed(""))
+	assert.Nil(t, embed(" ,.;!? "))
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	vec := make([]float32, 4)
+	for i := range vec {
+		vec[i] = float32(i) / 10
+	}
+	var gotAuth string
+	var gotBody map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&gotBody))
+		switch gotBody["input"] {
+		case "openai":
+			json.NewEncoder(w).Encode(map[string]any{"data": []map[string]any{{"embedding": vec}}})
+		case "short":
+			json.NewEncoder(w).Encode(map[string]any{"embedding": vec[:2]})
+		case "fail":
+			http.Error(w, "model overloaded", http.StatusServiceUnavailable)
+		default:
+			json.NewEncoder(w).Encode(map[string]any{"embedding": vec})
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("TEST_EMBEDDING_KEY", "secret")
+	e, err := NewHTTPEmbedder(4, config.EmbeddingHTTPConfig{
+		URL: server.URL, Model: "mini", Timeout: time.Second, APIKeyEnv: "TEST_EMBEDDING_KEY",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	got, err := e.Embed(ctx, "hiking")
+	require.NoError(t, err)
+	assert.Equal(t, vec, got)
+	assert.Equal(t, "Bearer secret", gotAuth)
+	assert.Equal(t, map[string]string{"model": "mini", "input": "hiking"}, gotBody)
+
+	got, err = e.Embed(ctx, "openai")
+	require.NoError(t, err)
+	assert.Equal(t, vec, got)
+
+	_, err = e.Embed(ctx, "short")
+	assert.ErrorContains(t, err, "2 dimensions")
+
+	_, err = e.Embed(ctx, "fail")
+	assert.ErrorContains(t, err, "model overloaded")
+
+	got, err = e.Embed(ctx, "  ")
+	require.NoError(t, err)
+	assert.Nil(t, got, "Empty text must not call the service")
+}
+
+func TestNewEmbedder(t *testing.T) {
+	e, err := NewEmbedder(&config.Config{Embedding: config.EmbeddingConfig{Provider: EmbeddingProviderHashing, Dimensions: 384}})
+	require.NoError(t, err)
+	assert.Equal(t, 384, e.Dimensions())
+
+	invalid := []config.EmbeddingConfig{
+		{Provider: EmbeddingProviderHashing},
+		{Provider: "word2vec", Dimensions: 384},
+		{Provider: EmbeddingProviderHTTP, Dimensions: 384},
+		{Provider: EmbeddingProviderHTTP, Dimensions: 384, HTTP: config.EmbeddingHTTPConfig{URL: "http://localhost", APIKeyEnv: "UNSET_EMBEDDING_KEY"}},
+	}
+	for _, ec := range invalid {
+		_, err := NewEmbedder(&config.Config{Embedding: ec})
+		assert.Error(t, err, "%+v", ec)
+	}
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fileRoot 是一个允许访问的根目录
+type fileRoot struct {
+	path string // 绝对路径
+	real string // 展开符号链接后的真实路径
+}
+
+// fileRoots 文件接口允许访问的根目录集合
+type fileRoots []fileRoot
+
+// newFileRoots 解析配置中的根目录，无法访问的目录会被忽略
+func newFileRoots(dirs []string, logger *zap.Logger) fileRoots {
+	roots := make(fileRoots, 0, len(dirs))
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			logger.Warn("Ignoring invalid file root", zap.String("root", dir), zap.Error(err))
+			continue
+		}
+		real, err := filepath.EvalSymlinks(abs)
+		if err != nil {
+			logger.Warn("Ignoring inaccessible file root", zap.String("root", dir), zap.Error(err))
+			continue
+		}
+		roots = append(roots, fileRoot{path: abs, real: real})
+	}
+	return roots
+}
+
+// resolve 将请求路径解析为根目录内的真实路径
+// 相对路径依次在各根目录下查找，绝对路径必须位于某个根目录之内；
+// 包含".."或经符号链接逃逸出根目录的路径返回PermissionDenied，不存在返回NotFound
+func (r fileRoots) resolve(requested string) (string, error) {
+	_, real, err := r.resolveEntry(requested)
+	return real, err
+}
+
+// resolveEntry 与resolve相同，另外返回展开符号链接之前的路径，Lstat该路径可以得到符号链接本身的信息
+func (r fileRoots) resolveEntry(requested string) (entry, real string, err error) {
+	if requested == "" {
+		return "", "", status.Error(codes.InvalidArgument, "file path cannot be empty")
+	}
+	if hasDotDot(requested) {
+		return "", "", status.Errorf(codes.PermissionDenied, "path %q must not contain '..'", requested)
+	}
+	if len(r) == 0 {
+		return "", "", status.Error(codes.PermissionDenied, "no file roots are configured")
+	}
+
+	cleaned := filepath.Clean(requested)
+	for _, root := range r {
+		candidate := filepath.Join(root.path, cleaned)
+		if filepath.IsAbs(cleaned) {
+			if !isWithin(root.path, cleaned) {
+				continue
+			}
+			candidate = cleaned
+		}
+
+		real, err := filepath.EvalSymlinks(candidate)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", "", status.Errorf(codes.Internal, "failed to resolve path: %v", err)
+		}
+		if !isWithin(root.real, real) {
+			return "", "", status.Errorf(codes.PermissionDenied, "path %q escapes the file root", requested)
+		}
+		return candidate, real, nil
+	}
+
+	if filepath.IsAbs(cleaned) && !r.contains(cleaned) {
+		return "", "", status.Errorf(codes.PermissionDenied, "path %q is outside the file roots", requested)
+	}
+	return "", "", status.Errorf(codes.NotFound, "file %q not found", requested)
+}
+
+// resolveFile 解析需要读取的文件路径，路径必须指向普通文件
+// 目录（如"."或"dir/"）、设备和命名管道返回InvalidArgument，在打开之前检查，避免在命名管道上阻塞
+func (r fileRoots) resolveFile(requested string) (string, error) {
+	path, err := r.resolve(requested)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", status.Errorf(codes.NotFound, "file %q not found", requested)
+	}
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to stat file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", status.Errorf(codes.InvalidArgument, "%q is not a regular file", requested)
+	}
+	return path, nil
+}
+
+// resolveTarget 解析待写入文件的目标路径
+// 相对路径写入第一个根目录，绝对路径必须位于某个根目录之内；父目录必须已存在且不能逃逸出根目录
+func (r fileRoots) resolveTarget(requested string) (string, error) {
+	if requested == "" {
+		return "", status.Error(codes.InvalidArgument, "file name cannot be empty")
+	}
+	if hasDotDot(requested) {
+		return "", status.Errorf(codes.PermissionDenied, "path %q must not contain '..'", requested)
+	}
+	if len(r) == 0 {
+		return "", status.Error(codes.PermissionDenied, "no file roots are configured")
+	}
+
+	cleaned := filepath.Clean(requested)
+	root := r[0]
+	candidate := filepath.Join(root.path, cleaned)
+	if filepath.IsAbs(cleaned) {
+		found := false
+		for _, rt := range r {
+			if isWithin(rt.path, cleaned) {
+				root, candidate, found = rt, cleaned, true
+				break
+			}
+		}
+		if !found {
+			return "", status.Errorf(codes.PermissionDenied, "path %q is outside the file roots", requested)
+		}
+	}
+	if candidate == root.path {
+		return "", status.Errorf(codes.InvalidArgument, "path %q does not name a file", requested)
+	}
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(candidate))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", status.Errorf(codes.NotFound, "directory of %q not found", requested)
+	}
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "failed to resolve path: %v", err)
+	}
+	if !isWithin(root.real, parent) {
+		return "", status.Errorf(codes.PermissionDenied, "path %q escapes the file root", requested)
+	}
+
+	target := filepath.Join(parent, filepath.Base(candidate))
+	if info, err := os.Lstat(target); err == nil && info.IsDir() {
+		return "", status.Errorf(codes.InvalidArgument, "path %q is a directory", requested)
+	}
+	return target, nil
+}
+
+// contains 判断绝对路径是否位于任一根目录之内
+func (r fileRoots) contains(path string) bool {
+	for _, root := range r {
+		if isWithin(root.path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDotDot 判断路径中是否包含".."片段
+func hasDotDot(path string) bool {
+	for _, part := range strings.Split(filepath.ToSlash(path), "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isWithin 判断path是否等于root或位于root之下
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	pb "tx/proto/gen"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultListPageSize = 100  // ListFiles默认每页条数
+	maxListPageSize     = 1000 // ListFiles每页条数上限
+)
+
+// errPageFull 用于在一页填满后提前结束目录遍历
+var errPageFull = errors.New("page full")
+
+// StatFile 获取文件根目录内文件的信息
+// 路径本身是符号链接时返回链接的信息（类型为FILE_TYPE_SYMLINK），链接目标同样必须位于根目录之内
+func (s *SystemService) StatFile(ctx context.Context, req *pb.StatFileRequest) (*pb.FileInfo, error) {
+	entry, _, err := s.roots.resolveEntry(req.FilePath)
+	if err != nil {
+		s.logger.Warn("Rejected file path", zap.String("path", req.FilePath), zap.Error(err))
+		return nil, err
+	}
+	info, err := os.Lstat(entry)
+	if err != nil {
+		s.logger.Error("Failed to stat file", zap.String("path", entry), zap.Error(err))
+		return nil, openError(err)
+	}
+	return newFileInfo(filepath.ToSlash(filepath.Clean(req.FilePath)), info), nil
+}
+
+// ListFiles 分页列出文件根目录内某个目录的文件
+// 结果按目录遍历顺序排列，page_token为上一页最后一项的路径
+func (s *SystemService) ListFiles(ctx context.Context, req *pb.ListFilesRequest) (*pb.ListFilesResponse, error) {
+	if req.Pattern != "" {
+		if _, err := path.Match(req.Pattern, ""); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid pattern %q: %v", req.Pattern, err)
+		}
+	}
+	pageSize := int(req.PageSize)
+	if pageSize < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid page size %d", req.PageSize)
+	}
+	if pageSize == 0 {
+		pageSize = defaultListPageSize
+	}
+	pageSize = min(pageSize, maxListPageSize)
+
+	dir := req.Dir
+	if dir == "" {
+		dir = "."
+	}
+	real, err := s.roots.resolve(dir)
+	if err != nil {
+		s.logger.Warn("Rejected directory path", zap.String("path", req.Dir), zap.Error(err))
+		return nil, err
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return nil, openError(err)
+	}
+	if !info.IsDir() {
+		return nil, status.Errorf(codes.InvalidArgument, "%q is not a directory", req.Dir)
+	}
+
+	resp := &pb.ListFilesResponse{}
+	var last string
+	err = filepath.WalkDir(real, func(p string, d fs.DirEntry, err error) error {
+		if p == real {
+			return err
+		}
+		rel, relErr := filepath.Rel(real, p)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+		if err != nil {
+			// 无法读取的子目录跳过，不影响其余结果
+			s.logger.Warn("Skipping unreadable path", zap.String("path", p), zap.Error(err))
+			return nil
+		}
+
+		descend := d.IsDir() && req.Recursive
+		if req.PageToken != "" && !walkBefore(req.PageToken, rel) {
+			// 已在之前的页返回过；子树中的项都排在page_token之前时不再进入
+			if descend && rel != req.PageToken && !strings.HasPrefix(req.PageToken, rel+"/") {
+				return fs.SkipDir
+			}
+			return skipUnlessDescend(d, descend)
+		}
+
+		if req.Pattern == "" || matchName(req.Pattern, d.Name()) {
+			if len(resp.Files) == pageSize {
+				resp.NextPageToken = last
+				return errPageFull
+			}
+			fi, err := d.Info()
+			if err != nil {
+				// 遍历过程中被删除的文件直接忽略
+				return skipUnlessDescend(d, descend)
+			}
+			resp.Files = append(resp.Files, newFileInfo(path.Join(filepath.ToSlash(dir), rel), fi))
+			last = rel
+		}
+		return skipUnlessDescend(d, descend)
+	})
+	if err != nil && !errors.Is(err, errPageFull) {
+		s.logger.Error("Failed to list directory", zap.String("path", real), zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to list directory: %v", err)
+	}
+	return resp, nil
+}
+
+// skipUnlessDescend 非递归列出时不进入子目录
+func skipUnlessDescend(d fs.DirEntry, descend bool) error {
+	if d.IsDir() && !descend {
+		return fs.SkipDir
+	}
+	return nil
+}
+
+// matchName 判断文件名是否匹配glob模式
+func matchName(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// walkBefore 判断按WalkDir的遍历顺序a是否在b之前
+// WalkDir按路径分段逐级比较，父目录总是在其子项之前
+func walkBefore(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// newFileInfo 将os.FileInfo转换为响应消息
+func newFileInfo(p string, info os.FileInfo) *pb.FileInfo {
+	fileType := pb.FileType_FILE_TYPE_UNKNOWN
+	switch {
+	case info.Mode().IsRegular():
+		fileType = pb.FileType_FILE_TYPE_REGULAR
+	case info.IsDir():
+		fileType = pb.FileType_FILE_TYPE_DIRECTORY
+	case info.Mode()&os.ModeSymlink != 0:
+		fileType = pb.FileType_FILE_TYPE_SYMLINK
+	}
+	return &pb.FileInfo{
+		Path:    p,
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    uint32(info.Mode().Perm()),
+		ModTime: timestamppb.New(info.ModTime()),
+		Type:    fileType,
+	}
+}
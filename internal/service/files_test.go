@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+	pb "tx/proto/gen"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSystemService_ListAndStatFiles(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	files := map[string]string{
+		"a.log":         "aaaa",
+		"a-b.txt":       "ab",
+		"b/c.log":       "ccc",
+		"b/d/e.txt":     "eeeee",
+		"b/d/f.log":     "f",
+		"z.csv":         "1,2,3",
+		"b/zz/deep.log": "deep",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o640))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o600))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "link")))
+
+	cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}}}
+	service := NewSystemService(zap.NewNop(), cfg)
+	ctx := context.Background()
+
+	paths := func(infos []*pb.FileInfo) []string {
+		out := make([]string, 0, len(infos))
+		for _, info := range infos {
+			out = append(out, info.Path)
+		}
+		return out
+	}
+
+	// listAll follows next_page_token until the listing is exhausted.
+	listAll := func(t *testing.T, req *pb.ListFilesRequest) []string {
+		t.Helper()
+		var all []string
+		for page := 0; ; page++ {
+			require.Less(t, page, 100, "Pagination must terminate")
+			resp, err := service.ListFiles(ctx, req)
+			require.NoError(t, err)
+			if req.PageSize > 0 {
+				require.LessOrEqual(t, len(resp.Files), int(req.PageSize))
+			}
+			all = append(all, paths(resp.Files)...)
+			if resp.NextPageToken == "" {
+				return all
+			}
+			req.PageToken = resp.NextPageToken
+		}
+	}
+
+	t.Run("list root non-recursively", func(t *testing.T) {
+		resp, err := service.ListFiles(ctx, &pb.ListFilesRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a-b.txt", "a.log", "b", "link", "z.csv"}, paths(resp.Files))
+		assert.Empty(t, resp.NextPageToken)
+
+		types := map[string]pb.FileType{}
+		for _, f := range resp.Files {
+			types[f.Name] = f.Type
+		}
+		assert.Equal(t, pb.FileType_FILE_TYPE_REGULAR, types["a.log"])
+		assert.Equal(t, pb.FileType_FILE_TYPE_DIRECTORY, types["b"])
+		assert.Equal(t, pb.FileType_FILE_TYPE_SYMLINK, types["link"], "Symlinks are reported, not followed")
+	})
+
+	t.Run("list recursively with glob", func(t *testing.T) {
+		resp, err := service.ListFiles(ctx, &pb.ListFilesRequest{Pattern: "*.log", Recursive: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a.log", "b/c.log", "b/d/f.log", "b/zz/deep.log"}, paths(resp.Files))
+	})
+
+	t.Run("list subdirectory", func(t *testing.T) {
+		resp, err := service.ListFiles(ctx, &pb.ListFilesRequest{Dir: "b/d"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b/d/e.txt", "b/d/f.log"}, paths(resp.Files))
+	})
+
+	t.Run("pagination returns every entry exactly once", func(t *testing.T) {
+		full, err := service.ListFiles(ctx, &pb.ListFilesRequest{Recursive: true})
+		require.NoError(t, err)
+		require.Len(t, full.Files, 11)
+
+		for _, size := range []int32{1, 2, 3, 5, 11} {
+			assert.Equal(t, paths(full.Files), listAll(t, &pb.ListFilesRequest{Recursive: true, PageSize: size}), "page size %d", size)
+		}
+		assert.Equal(t, []string{"a.log", "b/c.log", "b/d/f.log", "b/zz/deep.log"},
+			listAll(t, &pb.ListFilesRequest{Recursive: true, Pattern: "*.log", PageSize: 1}))
+	})
+
+	t.Run("stat file", func(t *testing.T) {
+		modTime := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+		require.NoError(t, os.Chtimes(filepath.Join(root, "b", "c.log"), modTime, modTime))
+
+		info, err := service.StatFile(ctx, &pb.StatFileRequest{FilePath: "b/c.log"})
+		require.NoError(t, err)
+		assert.Equal(t, "b/c.log", info.Path)
+		assert.Equal(t, "c.log", info.Name)
+		assert.Equal(t, int64(3), info.Size)
+		assert.Equal(t, uint32(0o640), info.Mode)
+		assert.Equal(t, pb.FileType_FILE_TYPE_REGULAR, info.Type)
+		assert.True(t, info.ModTime.AsTime().Equal(modTime))
+
+		dir, err := service.StatFile(ctx, &pb.StatFileRequest{FilePath: "b"})
+		require.NoError(t, err)
+		assert.Equal(t, pb.FileType_FILE_TYPE_DIRECTORY, dir.Type)
+	})
+
+	t.Run("rejected requests", func(t *testing.T) {
+		_, err := service.StatFile(ctx, &pb.StatFileRequest{FilePath: "link/secret.txt"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = service.StatFile(ctx, &pb.StatFileRequest{FilePath: "../secret.txt"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = service.StatFile(ctx, &pb.StatFileRequest{FilePath: "missing.txt"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		_, err = service.ListFiles(ctx, &pb.ListFilesRequest{Dir: "link"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = service.ListFiles(ctx, &pb.ListFilesRequest{Dir: "/etc"})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = service.ListFiles(ctx, &pb.ListFilesRequest{Dir: "a.log"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = service.ListFiles(ctx, &pb.ListFilesRequest{Pattern: "[a-"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = service.ListFiles(ctx, &pb.ListFilesRequest{PageSize: -1})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
+
+func TestSystemService_StatFile_Symlink(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "releases"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "releases", "v2.log"), []byte("v2"), 0o644))
+	require.NoError(t, os.Symlink(filepath.Join("releases", "v2.log"), filepath.Join(root, "current.log")))
+	require.NoError(t, os.Symlink("releases", filepath.Join(root, "latest")))
+	cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}}}
+	service := NewSystemService(zap.NewNop(), cfg)
+	ctx := context.Background()
+
+	tests := []struct {
+		path string
+		name string
+		want pb.FileType
+	}{
+		{"current.log", "current.log", pb.FileType_FILE_TYPE_SYMLINK},
+		{"latest", "latest", pb.FileType_FILE_TYPE_SYMLINK},
+		// Links in parent directories are followed; only the last element is reported as-is.
+		{"latest/v2.log", "v2.log", pb.FileType_FILE_TYPE_REGULAR},
+		{"releases", "releases", pb.FileType_FILE_TYPE_DIRECTORY},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			info, err := service.StatFile(ctx, &pb.StatFileRequest{FilePath: tt.path})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, info.Type)
+			assert.Equal(t, tt.name, info.Name)
+		})
+	}
+
+	// The link target is still what SendFile serves.
+	stream := &mockSystem_SendFileServer{}
+	require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: "current.log"}, stream))
+	assert.Equal(t, "v2", stream.received())
+}
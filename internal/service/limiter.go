@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tx/internal/config"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userQuotaIdle 用户没有传输超过这个时间后清理其配额
+// 令牌桶容量为一秒的流量，空闲一秒后桶已补满，重新创建不会让用户得到额外的令牌
+const userQuotaIdle = time.Second
+
+// transferLimiter 基于令牌桶限制文件传输的带宽，并限制每个用户同时进行的传输数
+// 用户的令牌桶在连续的传输之间保留，依次打开新的传输不会重新得到一整桶令牌
+type transferLimiter struct {
+	global     *rate.Limiter // 全局带宽，nil表示不限制
+	userRate   int64         // 每个用户的带宽（字节/秒），0表示不限制
+	maxStreams int           // 每个用户的并发传输上限，0表示不限制
+	now        func() time.Time
+
+	mu        sync.Mutex
+	users     map[string]*userQuota
+	lastSweep time.Time
+}
+
+// userQuota 一个用户当前的传输配额
+type userQuota struct {
+	limiter  *rate.Limiter // 用户带宽，nil表示不限制
+	streams  int           // 正在进行的传输数
+	lastUsed time.Time     // 最近一次传输结束的时间
+}
+
+// newTransferLimiter 根据配置创建传输限流器
+func newTransferLimiter(cfg config.TransferConfig) *transferLimiter {
+	return &transferLimiter{
+		global:     newByteLimiter(cfg.GlobalBytesPerSecond),
+		userRate:   cfg.UserBytesPerSecond,
+		maxStreams: cfg.MaxStreamsPerUser,
+		now:        time.Now,
+		users:      make(map[string]*userQuota),
+	}
+}
+
+// newByteLimiter 创建每秒补充bytesPerSecond个令牌、桶容量为一秒流量的令牌桶
+func newByteLimiter(bytesPerSecond int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+// acquire 为用户登记一个新的传输，超过并发上限时返回ResourceExhausted
+// 调用方必须在传输结束后调用release
+func (l *transferLimiter) acquire(userID string) (*userQuota, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep()
+	quota, ok := l.users[userID]
+	if !ok {
+		quota = &userQuota{limiter: newByteLimiter(l.userRate)}
+		l.users[userID] = quota
+	}
+	if l.maxStreams > 0 && quota.streams >= l.maxStreams {
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"too many concurrent transfers: limit is %d per user", l.maxStreams)
+	}
+	quota.streams++
+	return quota, nil
+}
+
+// release 结束用户的一个传输，配额保留到用户空闲超过userQuotaIdle
+func (l *transferLimiter) release(userID string, quota *userQuota) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	quota.streams--
+	quota.lastUsed = l.now()
+}
+
+// sweep 清理空闲超过userQuotaIdle的用户配额，每个userQuotaIdle最多扫描一次，调用方必须持有l.mu
+func (l *transferLimiter) sweep() {
+	now := l.now()
+	if now.Sub(l.lastSweep) < userQuotaIdle {
+		return
+	}
+	l.lastSweep = now
+	for userID, quota := range l.users {
+		if quota.streams == 0 && now.Sub(quota.lastUsed) >= userQuotaIdle {
+			delete(l.users, userID)
+		}
+	}
+}
+
+// wait 阻塞直到全局和用户令牌桶都允许传输n个字节
+func (l *transferLimiter) wait(ctx context.Context, quota *userQuota, n int) error {
+	for _, limiter := range []*rate.Limiter{l.global, quota.limiter} {
+		if limiter == nil {
+			continue
+		}
+		// 单次请求不能超过桶容量，大块分多次等待
+		for remaining := n; remaining > 0; {
+			step := min(remaining, limiter.Burst())
+			if err := limiter.WaitN(ctx, step); err != nil {
+				if cerr := contextError(ctx); cerr != nil {
+					return cerr
+				}
+				return status.Errorf(codes.DeadlineExceeded, "transfer would exceed deadline: %v", err)
+			}
+			remaining -= step
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"tx/pkg/utils"
+	pb "tx/proto/gen"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// errProfileModified 资料在客户端读取之后已被修改
+var errProfileModified = errors.New("profile has been modified")
+
+// profileUpdate 要修改的字段
+type profileUpdate struct {
+	username   *string // 展示用的用户名，nil表示不修改
+	normalized *string // 用户名的规范形式，与username同时设置
+	setLikes   bool
+	likes      *string // setLikes为true时写入，nil写入NULL
+	embedding  []float32
+}
+
+// profile 修改后的资料
+type profile struct {
+	username      string
+	normalized    string
+	oldNormalized string // 修改前用户名的规范形式
+	passwordHash  string
+	likes         *string
+	updatedAt     time.Time
+}
+
+// UpdateProfile 修改用户名或喜好，喜好变化时重新计算向量
+// 请求需要带上读取资料时的updated_at，与数据库中的值不一致时返回Aborted，客户端应重新读取后再修改
+func (s *UserService) UpdateProfile(ctx context.Context, req *pb.UpdateProfileRequest) (*pb.UpdateProfileResponse, error) {
+	// 检查参数是否合理
+	if req.UserId == "" {
+		return nil, status.Error(codes.InvalidArgument, "userId cannot be empty")
+	}
+	if req.Username == nil && req.Likes == nil {
+		return nil, status.Error(codes.InvalidArgument, "at least one of username and likes must be set")
+	}
+	if err := req.ExpectedUpdatedAt.CheckValid(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "expected_updated_at must be a valid timestamp")
+	}
+	// 只允许修改自己的资料，管理员除外
+	if err := authorizeUserAccess(ctx, req.UserId); err != nil {
+		s.logger.Warn("user update profile denied", zap.String("userId", req.UserId), zap.Error(err))
+		return nil, err
+	}
+
+	var update profileUpdate
+	if req.Username != nil {
+		username := utils.CleanUsername(*req.Username)
+		normalized := utils.NormalizeUsername(*req.Username)
+		if violations := fieldViolations("username", s.usernames.Validate(normalized)); len(violations) > 0 {
+			return nil, badRequestError("invalid username", violations)
+		}
+		update.username, update.normalized = &username, &normalized
+	}
+	if req.Likes != nil {
+		// 在事务之外计算向量，避免等待embedding服务时持有行锁
+		// 计算失败时向量写入NULL，旧向量与新喜好不再对应，之后由回填任务补齐
+		update.setLikes = true
+		update.likes, update.embedding = s.embedLikes(ctx, *req.Likes)
+	}
+
+	p, err := s.updateProfile(ctx, req.UserId, req.ExpectedUpdatedAt.AsTime(), update)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, status.Error(codes.NotFound, "user not found")
+	case errors.Is(err, errProfileModified):
+		return nil, status.Error(codes.Aborted, "profile has been modified, reload it and retry")
+	case isUniqueViolation(err):
+		return nil, status.Error(codes.AlreadyExists, "username already exists")
+	case err != nil:
+		s.logger.Error("user update profile failed", zap.String("userId", req.UserId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to update profile")
+	}
+	if p.normalized != p.oldNormalized {
+		s.moveCredentialCache(ctx, req.UserId, p)
+	}
+	s.logger.Info("user update profile success", zap.String("userId", req.UserId), zap.String("username", p.username))
+
+	resp := &pb.UpdateProfileResponse{
+		UserId:    req.UserId,
+		Username:  p.username,
+		UpdatedAt: timestamppb.New(p.updatedAt),
+	}
+	if p.likes != nil {
+		resp.Likes = *p.likes
+	}
+	return resp, nil
+}
+
+// updateProfile 在事务中锁住用户并比较updated_at，一致时再修改，updated_at由触发器更新
+// 用户不存在时返回pgx.ErrNoRows，updated_at不一致时返回errProfileModified
+func (s *UserService) updateProfile(ctx context.Context, userID string, expected time.Time, u profileUpdate) (profile, error) {
+	var p profile
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return p, err
+	}
+	defer tx.Rollback(ctx)
+
+	var updatedAt time.Time
+	err = tx.QueryRow(ctx, "SELECT username_normalized, updated_at FROM users WHERE id = $1 FOR UPDATE", userID).
+		Scan(&p.oldNormalized, &updatedAt)
+	if err != nil {
+		return p, err
+	}
+	if !updatedAt.Equal(expected) {
+		return p, errProfileModified
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE users SET
+			username = COALESCE($2, username),
+			username_normalized = COALESCE($3, username_normalized),
+			likes = CASE WHEN $4::boolean THEN $5 ELSE likes END,
+			like_embedding = CASE WHEN $4::boolean THEN $6::vector ELSE like_embedding END
+		WHERE id = $1
+		RETURNING username, username_normalized, password, likes, updated_at`,
+		userID, u.username, u.normalized, u.setLikes, u.likes, u.embedding).
+		Scan(&p.username, &p.normalized, &p.passwordHash, &p.likes, &p.updatedAt)
+	if err != nil {
+		return p, err
+	}
+	return p, tx.Commit(ctx)
+}
+
+// moveCredentialCache 用户名修改后把register:和login:缓存移到新的用户名下
+// 旧用户名的缓存必须删除，否则旧用户名仍能通过缓存登录；新用户名的缓存写入失败时登录会回退到Postgres
+func (s *UserService) moveCredentialCache(ctx context.Context, userID string, p profile) {
+	if err := s.EnsureRedisDel(ctx, registerKey(p.oldNormalized), loginKey(p.oldNormalized)); err != nil {
+		s.logger.Error("delete stale credential cache failed", zap.String("userId", userID),
+			zap.String("username", p.oldNormalized), zap.Error(err))
+	}
+	go s.EnsureRedisSet(ctx, registerKey(p.normalized), userID, 0)
+	go s.EnsureRedisSet(ctx, loginKey(p.normalized), p.passwordHash, 0)
+}
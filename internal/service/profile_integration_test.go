@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+	"tx/internal/interceptor"
+	"tx/pkg/db"
+	"tx/pkg/utils"
+	pb "tx/proto/gen"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultTestPostgresDSN matches the database started by docker-compose.yml.
+const defaultTestPostgresDSN = "host=localhost port=5432 user=mkitsdts password=mkitsdts dbname=tx_test sslmode=disable"
+
+// newMigratedPostgres connects to the Postgres named by TX_TEST_POSTGRES_DSN, a keyword/value
+// DSN defaulting to the docker-compose database, and applies the users migrations in a throwaway schema.
+// The test is skipped when the database cannot be reached.
+func newMigratedPostgres(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	dsn := os.Getenv("TX_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		dsn = defaultTestPostgresDSN
+	}
+	ctx := context.Background()
+
+	admin, err := pgxpool.New(ctx, dsn+" connect_timeout=2")
+	if err == nil {
+		err = admin.Ping(ctx)
+	}
+	if err != nil {
+		if admin != nil {
+			admin.Close()
+		}
+		t.Skipf("Postgres is not available: %v", err)
+	}
+	t.Cleanup(admin.Close)
+
+	schema := fmt.Sprintf("tx_test_%d", time.Now().UnixNano())
+	_, err = admin.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS vector")
+	require.NoError(t, err)
+	_, err = admin.Exec(ctx, "CREATE SCHEMA "+schema)
+	require.NoError(t, err)
+	t.Cleanup(func() { admin.Exec(context.Background(), "DROP SCHEMA "+schema+" CASCADE") })
+
+	cfg, err := pgxpool.ParseConfig(dsn + " search_path=" + schema + ",public")
+	require.NoError(t, err)
+	cfg.AfterConnect = db.RegisterVectorType
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	// The users table as created by user.sql, which also issues a CREATE DATABASE
+	// that cannot run inside an existing database.
+	migrations := []string{`CREATE TABLE users (
+		id VARCHAR(36) PRIMARY KEY,
+		username VARCHAR(255) NOT NULL UNIQUE,
+		password VARCHAR(255) NOT NULL,
+		likes TEXT NULL,
+		like_embedding vector(384) NULL,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	)`}
+	for _, name := range []string{
+		"user_003_roles.sql",
+		"user_004_normalize_usernames.sql",
+		"user_005_updated_at.sql",
+		"user_006_username_normalized_key.sql",
+	} {
+		sql, err := os.ReadFile(filepath.Join("..", "..", "migrations", name))
+		require.NoError(t, err)
+		migrations = append(migrations, string(sql))
+	}
+	conn, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+	for _, sql := range migrations {
+		// The simple protocol runs every statement of a migration file.
+		_, err := conn.Conn().PgConn().Exec(ctx, sql).ReadAll()
+		require.NoError(t, err)
+	}
+	return pool
+}
+
+func TestUserService_UpdateProfile_Postgres(t *testing.T) {
+	pool := newMigratedPostgres(t)
+	const userID = "1111111111"
+	ctx := context.WithValue(context.Background(), interceptor.UserIDKey, userID)
+
+	_, err := pool.Exec(ctx, `INSERT INTO users (id, username, username_normalized, password, likes)
+		VALUES ($1, 'Alice', 'alice', 'hash', 'reading')`, userID)
+	require.NoError(t, err)
+	updatedAt := func(t *testing.T) time.Time {
+		var at time.Time
+		require.NoError(t, pool.QueryRow(ctx, "SELECT updated_at FROM users WHERE id = $1", userID).Scan(&at))
+		return at
+	}
+
+	usernames, err := utils.NewUsernamePolicy(&config.Config{Auth: config.AuthConfig{
+		Username: config.UsernameConfig{MinLength: 3, MaxLength: 32},
+	}})
+	require.NoError(t, err)
+	svc := &UserService{db: pool, logger: zap.NewNop(), usernames: usernames, embedder: NewHashingEmbedder(384)}
+
+	read := updatedAt(t)
+	resp, err := svc.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+		UserId: userID, Likes: proto.String("jazz"), ExpectedUpdatedAt: timestamppb.New(read),
+	})
+	require.NoError(t, err)
+	current := resp.UpdatedAt.AsTime()
+	assert.True(t, current.After(read), "the trigger bumps updated_at when likes change")
+	assert.True(t, current.Equal(updatedAt(t)))
+
+	t.Run("StaleUpdatedAtIsRejected", func(t *testing.T) {
+		// A second client still holds the version read before the first update.
+		_, err := svc.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			UserId: userID, Likes: proto.String("chess"), ExpectedUpdatedAt: timestamppb.New(read),
+		})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+
+		var likes string
+		require.NoError(t, pool.QueryRow(ctx, "SELECT likes FROM users WHERE id = $1", userID).Scan(&likes))
+		assert.Equal(t, "jazz", likes, "the first update must not be lost")
+	})
+
+	t.Run("NoOpUpdateKeepsUpdatedAt", func(t *testing.T) {
+		resp, err := svc.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			UserId: userID, Likes: proto.String("jazz"), ExpectedUpdatedAt: timestamppb.New(current),
+		})
+		require.NoError(t, err)
+		assert.True(t, resp.UpdatedAt.AsTime().Equal(current))
+
+		// Writes outside the editable profile do not invalidate the client's version either.
+		_, err = pool.Exec(ctx, `UPDATE users SET password = 'rehashed', roles = '{ops}', like_embedding = NULL
+			WHERE id = $1`, userID)
+		require.NoError(t, err)
+		assert.True(t, updatedAt(t).Equal(current))
+
+		_, err = svc.UpdateProfile(ctx, &pb.UpdateProfileRequest{
+			UserId: userID, Likes: proto.String("chess"), ExpectedUpdatedAt: timestamppb.New(current),
+		})
+		assert.NoError(t, err, "the version read before the no-op updates is still current")
+	})
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+	"tx/internal/interceptor"
+	"tx/pkg/db"
+	"tx/pkg/db/dbtest"
+	"tx/pkg/utils"
+	pb "tx/proto/gen"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestUserService_UpdateProfile_InvalidRequests(t *testing.T) {
+	usernames, err := utils.NewUsernamePolicy(&config.Config{Auth: config.AuthConfig{
+		Username: config.UsernameConfig{MinLength: 3, MaxLength: 32, AllowedSymbols: "._-", Reserved: []string{"admin"}},
+	}})
+	require.NoError(t, err)
+	// Requests are rejected before the database is queried.
+	svc := &UserService{logger: zap.NewNop(), usernames: usernames}
+	ctx := context.WithValue(context.Background(), interceptor.UserIDKey, "1111111111")
+	version := timestamppb.Now()
+
+	tests := []struct {
+		name string
+		req  *pb.UpdateProfileRequest
+		code codes.Code
+	}{
+		{"NoUserID", &pb.UpdateProfileRequest{Likes: proto.String("hiking"), ExpectedUpdatedAt: version}, codes.InvalidArgument},
+		{"NothingToUpdate", &pb.UpdateProfileRequest{UserId: "1111111111", ExpectedUpdatedAt: version}, codes.InvalidArgument},
+		{"NoExpectedUpdatedAt", &pb.UpdateProfileRequest{UserId: "1111111111", Likes: proto.String("hiking")}, codes.InvalidArgument},
+		{"InvalidExpectedUpdatedAt", &pb.UpdateProfileRequest{UserId: "1111111111", Likes: proto.String("hiking"),
+			ExpectedUpdatedAt: &timestamppb.Timestamp{Nanos: -1}}, codes.InvalidArgument},
+		{"OtherUser", &pb.UpdateProfileRequest{UserId: "2222222222", Likes: proto.String("hiking"), ExpectedUpdatedAt: version}, codes.PermissionDenied},
+		{"ReservedUsername", &pb.UpdateProfileRequest{UserId: "1111111111", Username: proto.String(" Admin "), ExpectedUpdatedAt: version}, codes.InvalidArgument},
+		{"EmptyUsername", &pb.UpdateProfileRequest{UserId: "1111111111", Username: proto.String(""), ExpectedUpdatedAt: version}, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.UpdateProfile(ctx, tt.req)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestUserService_UpdateProfile(t *testing.T) {
+	const userID = "1111111111"
+	current := time.Date(2024, 5, 1, 8, 30, 0, 123456000, time.UTC)
+	next := current.Add(time.Minute)
+
+	srv := dbtest.NewServer(t)
+	srv.HandleVector()
+	srv.HandleTransactions()
+	srv.Handle("SELECT username_normalized, updated_at FROM users WHERE id = $1 FOR UPDATE", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID},
+		Columns:   []dbtest.Column{{Name: "username_normalized", OID: pgtype.TextOID}, {Name: "updated_at", OID: pgtype.TimestamptzOID}},
+		Rows: func(q dbtest.Query) [][]any {
+			if string(q.Params[0]) == userID {
+				return [][]any{{"alice", current}}
+			}
+			return nil
+		},
+	})
+	// The stand-in echoes the new values back the way the UPDATE ... RETURNING would.
+	srv.Handle("UPDATE users SET", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID, pgtype.TextOID, pgtype.TextOID, pgtype.BoolOID, pgtype.TextOID, dbtest.VectorOID},
+		Columns: []dbtest.Column{
+			{Name: "username", OID: pgtype.TextOID},
+			{Name: "username_normalized", OID: pgtype.TextOID},
+			{Name: "password", OID: pgtype.TextOID},
+			{Name: "likes", OID: pgtype.TextOID},
+			{Name: "updated_at", OID: pgtype.TimestamptzOID},
+		},
+		Rows: func(q dbtest.Query) [][]any {
+			username, normalized := any("Alice"), any("alice")
+			if q.Params[1] != nil {
+				username, normalized = string(q.Params[1]), string(q.Params[2])
+			}
+			likes := any("reading")
+			if q.Params[3][0] == 1 {
+				likes = nil
+				if q.Params[4] != nil {
+					likes = string(q.Params[4])
+				}
+			}
+			return [][]any{{username, normalized, "hash", likes, next}}
+		},
+		Tag: "UPDATE 1",
+	})
+	pool, err := db.NewPostgresClient(&config.Config{Postgres: srv.Config()})
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	usernames, err := utils.NewUsernamePolicy(&config.Config{Auth: config.AuthConfig{
+		Username: config.UsernameConfig{MinLength: 3, MaxLength: 32},
+	}})
+	require.NoError(t, err)
+	const dims = 8
+	svc := &UserService{db: pool, logger: zap.NewNop(), usernames: usernames, embedder: NewHashingEmbedder(dims)}
+	ctx := context.WithValue(context.Background(), interceptor.UserIDKey, userID)
+
+	lastUpdate := func(t *testing.T) dbtest.Query {
+		var last dbtest.Query
+		for _, q := range srv.Queries() {
+			if strings.Contains(q.SQL, "UPDATE users SET") {
+				last = q
+			}
+		}
+		require.NotEmpty(t, last.SQL, "no UPDATE was executed")
+		return last
+	}
+
+	t.Run("Likes", func(t *testing.T) {
+		resp, err := svc.UpdateProfile(ctx, &pb.UpdateProfileRequest{UserId: userID, Likes: proto.String(" hiking, jazz "), ExpectedUpdatedAt: timestamppb.New(current)})
+		require.NoError(t, err)
+		assert.True(t, proto.Equal(&pb.UpdateProfileResponse{UserId: userID, Username: "Alice", Likes: "hiking, jazz", UpdatedAt: timestamppb.New(next)}, resp), "got %v", resp)
+
+		q := lastUpdate(t)
+		assert.Nil(t, q.Params[1], "username must not change")
+		assert.Len(t, q.Params[5], 4+4*dims, "embedding must be recomputed")
+	})
+
+	t.Run("ClearLikes", func(t *testing.T) {
+		resp, err := svc.UpdateProfile(ctx, &pb.UpdateProfileRequest{UserId: userID, Likes: proto.String(""), ExpectedUpdatedAt: timestamppb.New(current)})
+		require.NoError(t, err)
+		assert.Empty(t, resp.Likes)
+
+		q := lastUpdate(t)
+		assert.Equal(t, []byte{1}, q.Params[3])
+		assert.Nil(t, q.Params[4])
+		assert.Nil(t, q.Params[5])
+	})
+
+	t.Run("UsernameCase", func(t *testing.T) {
+		// Same normalized form, so the Redis credential cache stays where it is.
+		resp, err := svc.UpdateProfile(ctx, &pb.UpdateProfileRequest{UserId: userID, Username: proto.String(" ALICE "), ExpectedUpdatedAt: timestamppb.New(current)})
+		require.NoError(t, err)
+		assert.Equal(t, "ALICE", resp.Username)
+		assert.Equal(t, "reading", resp.Likes)
+
+		q := lastUpdate(t)
+		assert.Equal(t, "alice", string(q.Params[2]))
+		assert.Equal(t, []byte{0}, q.Params[3], "likes must not change")
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		_, err := svc.UpdateProfile(ctx, &pb.UpdateProfileRequest{UserId: userID, Likes: proto.String("chess"), ExpectedUpdatedAt: timestamppb.New(current.Add(-time.Second))})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		adminCtx := interceptor.ContextWithPrincipal(context.Background(),
+			&interceptor.Principal{UserID: "9999999999", Roles: []string{interceptor.RoleAdmin}})
+		_, err := svc.UpdateProfile(adminCtx, &pb.UpdateProfileRequest{UserId: "3333333333", Likes: proto.String("chess"), ExpectedUpdatedAt: timestamppb.New(current)})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"errors"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"tx/internal/interceptor"
+	pb "tx/proto/gen"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxCosineDistance 余弦距离的最大值
+const maxCosineDistance = 2
+
+// similarCursor 分页位置，结果按(距离, 用户ID)排序，下一页从上一页最后一个用户之后开始
+type similarCursor struct {
+	distance float64
+	userID   string
+}
+
+// encodePageToken 将分页位置编码为不透明的字符串，距离按最短可还原的形式保存，保证下一页的比较与数据库一致
+func encodePageToken(c similarCursor) string {
+	raw := strconv.FormatFloat(c.distance, 'g', -1, 64) + "|" + c.userID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodePageToken 解析encodePageToken生成的字符串
+func decodePageToken(token string) (similarCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return similarCursor{}, err
+	}
+	distance, userID, ok := strings.Cut(string(raw), "|")
+	if !ok || userID == "" {
+		return similarCursor{}, errors.New("malformed page token")
+	}
+	d, err := strconv.ParseFloat(distance, 64)
+	if err != nil || math.IsNaN(d) || d < 0 || d > maxCosineDistance {
+		return similarCursor{}, errors.New("malformed page token")
+	}
+	return similarCursor{distance: d, userID: userID}, nil
+}
+
+// pageLimit 返回每页数量，0使用默认值，超过上限时截断
+func (s *UserService) pageLimit(limit int32) int {
+	sc := s.cfg.SimilarUsers
+	if limit <= 0 {
+		return sc.DefaultLimit
+	}
+	return min(int(limit), sc.MaxLimit)
+}
+
+// FindSimilarUsers 按喜好向量的余弦距离查找相似用户，支持分页和距离上限
+func (s *UserService) FindSimilarUsers(ctx context.Context, req *pb.FindSimilarUsersRequest) (*pb.FindSimilarUsersResponse, error) {
+	// 检查参数是否合理
+	if (req.UserId == "") == (strings.TrimSpace(req.Likes) == "") {
+		return nil, status.Error(codes.InvalidArgument, "exactly one of user_id and likes must be set")
+	}
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit cannot be negative")
+	}
+	if math.IsNaN(float64(req.MaxDistance)) || req.MaxDistance < 0 || req.MaxDistance > maxCosineDistance {
+		return nil, status.Errorf(codes.InvalidArgument, "max_distance must be between 0 and %d", maxCosineDistance)
+	}
+	var cursor *similarCursor
+	if req.PageToken != "" {
+		c, err := decodePageToken(req.PageToken)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		cursor = &c
+	}
+	// 排除查询的用户自己
+	exclude := req.UserId
+	if exclude == "" {
+		exclude, _ = ctx.Value(interceptor.UserIDKey).(string)
+	} else if err := authorizeUserAccess(ctx, req.UserId); err != nil {
+		return nil, err
+	}
+
+	embedding, err := s.queryEmbedding(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if embedding == nil {
+		return &pb.FindSimilarUsersResponse{}, nil
+	}
+
+	limit := s.pageLimit(req.Limit)
+	users, more, err := s.searchSimilar(ctx, embedding, exclude, float64(req.MaxDistance), cursor, limit)
+	if err != nil {
+		s.logger.Error("find similar users failed", zap.String("userId", req.UserId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to find similar users")
+	}
+	resp := &pb.FindSimilarUsersResponse{Users: users}
+	if more && len(users) > 0 {
+		last := users[len(users)-1]
+		resp.NextPageToken = encodePageToken(similarCursor{distance: last.Distance, userID: last.UserId})
+	}
+	return resp, nil
+}
+
+// queryEmbedding 返回查询使用的向量，喜好文本没有内容时返回nil
+func (s *UserService) queryEmbedding(ctx context.Context, req *pb.FindSimilarUsersRequest) ([]float32, error) {
+	if req.UserId == "" {
+		embedding, err := s.embedder.Embed(ctx, req.Likes)
+		if err != nil {
+			s.logger.Error("embed likes failed", zap.Error(err))
+			return nil, status.Error(codes.Unavailable, "failed to embed likes")
+		}
+		return embedding, nil
+	}
+
+	var embedding []float32
+	err := s.db.QueryRow(ctx, "SELECT like_embedding FROM users WHERE id = $1", req.UserId).Scan(&embedding)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		s.logger.Error("load user embedding failed", zap.String("userId", req.UserId), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to find similar users")
+	}
+	if embedding == nil {
+		return nil, status.Error(codes.FailedPrecondition, "user has no likes embedding")
+	}
+	return embedding, nil
+}
+
+// searchSimilar 返回cursor之后按(距离, 用户ID)排序的最多limit个用户，more表示可能还有下一页
+// 在事务中设置ivfflat.probes后按余弦距离查询，probes越大召回率越高、查询越慢
+func (s *UserService) searchSimilar(ctx context.Context, embedding []float32, exclude string,
+	maxDistance float64, cursor *similarCursor, limit int) (users []*pb.SimilarUser, more bool, err error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback(ctx)
+
+	if probes := s.cfg.SimilarUsers.IVFFlatProbes; probes > 0 {
+		// SET LOCAL不支持参数，使用set_config，只在当前事务内生效
+		if _, err := tx.Exec(ctx, "SELECT set_config('ivfflat.probes', $1, true)", strconv.Itoa(probes)); err != nil {
+			return nil, false, err
+		}
+	}
+
+	var afterDistance any
+	var afterID string
+	if cursor != nil {
+		afterDistance, afterID = cursor.distance, cursor.userID
+	}
+	// ivfflat索引只能提供按距离表达式的顺序，ORDER BY中再加其他排序键就无法使用索引，距离相同时在Go中按用户ID排序
+	// ivfflat是近似索引，只扫描probes个列表，过滤条件较严格时返回的结果可能少于limit
+	// 多查一条用于判断是否还有下一页
+	rows, err := tx.Query(ctx, `
+		SELECT id, username, like_embedding <=> $1::vector AS distance
+		FROM users
+		WHERE like_embedding IS NOT NULL AND id <> $2
+		  AND ($3::float8 = 0 OR like_embedding <=> $1::vector <= $3)
+		  AND ($4::float8 IS NULL OR (like_embedding <=> $1::vector, id) > ($4, $5::text))
+		ORDER BY like_embedding <=> $1::vector
+		LIMIT $6`,
+		embedding, exclude, maxDistance, afterDistance, afterID, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	users, err = collectSimilarUsers(rows)
+	if err != nil {
+		return nil, false, err
+	}
+	sortSimilarUsers(users)
+	if len(users) <= limit {
+		return users, false, tx.Commit(ctx)
+	}
+
+	boundary := users[limit].Distance
+	if users = completePage(users, limit); len(users) == 0 {
+		// 多查的这一批距离全部相同，按用户ID读取距离相同的用户，这个查询不使用索引，只在大量用户喜好相同时执行
+		tieAfter := ""
+		if cursor != nil && cursor.distance == boundary {
+			tieAfter = cursor.userID
+		}
+		rows, err := tx.Query(ctx, `
+			SELECT id, username, like_embedding <=> $1::vector AS distance
+			FROM users
+			WHERE like_embedding IS NOT NULL AND id <> $2
+			  AND like_embedding <=> $1::vector = $3 AND id > $4
+			ORDER BY id
+			LIMIT $5`,
+			embedding, exclude, boundary, tieAfter, limit)
+		if err != nil {
+			return nil, false, err
+		}
+		if users, err = collectSimilarUsers(rows); err != nil {
+			return nil, false, err
+		}
+	}
+	return users, true, tx.Commit(ctx)
+}
+
+// collectSimilarUsers 读取查询结果中的用户和距离
+func collectSimilarUsers(rows pgx.Rows) ([]*pb.SimilarUser, error) {
+	return pgx.CollectRows(rows, func(row pgx.CollectableRow) (*pb.SimilarUser, error) {
+		u := &pb.SimilarUser{}
+		err := row.Scan(&u.UserId, &u.Username, &u.Distance)
+		return u, err
+	})
+}
+
+// sortSimilarUsers 按(距离, 用户ID)排序，与分页位置的比较方式一致
+func sortSimilarUsers(users []*pb.SimilarUser) {
+	slices.SortFunc(users, func(a, b *pb.SimilarUser) int {
+		if c := cmp.Compare(a.Distance, b.Distance); c != 0 {
+			return c
+		}
+		return strings.Compare(a.UserId, b.UserId)
+	})
+}
+
+// completePage 从排好序、多查了一条的结果中取出一页
+// 数据库只按距离排序，LIMIT截断处距离相同的用户可能只返回了一部分，未必是其中ID最小的几个，
+// 页尾与最后一条距离相同的用户留到下一页，保证下一页从正确的位置开始；全部距离相同时返回空
+func completePage(users []*pb.SimilarUser, limit int) []*pb.SimilarUser {
+	boundary := users[limit].Distance
+	page := users[:limit]
+	for len(page) > 0 && page[len(page)-1].Distance == boundary {
+		page = page[:len(page)-1]
+	}
+	return page
+}
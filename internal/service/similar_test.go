@@ -0,0 +1,221 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"tx/internal/config"
+	"tx/internal/interceptor"
+	"tx/pkg/db"
+	"tx/pkg/db/dbtest"
+	pb "tx/proto/gen"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPageToken(t *testing.T) {
+	for _, c := range []similarCursor{
+		{distance: 0, userID: "1111111111"},
+		{distance: 0.12345678901234566, userID: "2222222222"},
+		{distance: 2, userID: "3333333333"},
+	} {
+		got, err := decodePageToken(encodePageToken(c))
+		require.NoError(t, err)
+		assert.Equal(t, c, got, "The distance must round-trip exactly so the next page starts after the last row")
+	}
+
+	for _, token := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("0.5")),
+		base64.RawURLEncoding.EncodeToString([]byte("0.5|")),
+		base64.RawURLEncoding.EncodeToString([]byte("abc|1111111111")),
+		base64.RawURLEncoding.EncodeToString([]byte("-1|1111111111")),
+		base64.RawURLEncoding.EncodeToString([]byte("NaN|1111111111")),
+		base64.RawURLEncoding.EncodeToString([]byte("3|1111111111")),
+	} {
+		_, err := decodePageToken(token)
+		assert.Error(t, err, token)
+	}
+}
+
+func TestUserService_PageLimit(t *testing.T) {
+	svc := &UserService{cfg: &config.Config{SimilarUsers: config.SimilarUsersConfig{DefaultLimit: 10, MaxLimit: 100}}}
+	assert.Equal(t, 10, svc.pageLimit(0))
+	assert.Equal(t, 25, svc.pageLimit(25))
+	assert.Equal(t, 100, svc.pageLimit(1000))
+}
+
+func TestUserService_FindSimilarUsers_InvalidRequests(t *testing.T) {
+	// Requests are rejected before the database is queried.
+	svc := &UserService{logger: zap.NewNop(), embedder: NewHashingEmbedder(384),
+		cfg: &config.Config{SimilarUsers: config.SimilarUsersConfig{DefaultLimit: 10, MaxLimit: 100}}}
+	ctx := context.WithValue(context.Background(), interceptor.UserIDKey, "1111111111")
+
+	tests := []struct {
+		name string
+		req  *pb.FindSimilarUsersRequest
+		code codes.Code
+	}{
+		{"NoQuery", &pb.FindSimilarUsersRequest{}, codes.InvalidArgument},
+		{"BlankLikes", &pb.FindSimilarUsersRequest{Likes: "   "}, codes.InvalidArgument},
+		{"BothQueries", &pb.FindSimilarUsersRequest{UserId: "1111111111", Likes: "hiking"}, codes.InvalidArgument},
+		{"NegativeLimit", &pb.FindSimilarUsersRequest{Likes: "hiking", Limit: -1}, codes.InvalidArgument},
+		{"NegativeDistance", &pb.FindSimilarUsersRequest{Likes: "hiking", MaxDistance: -0.1}, codes.InvalidArgument},
+		{"DistanceTooLarge", &pb.FindSimilarUsersRequest{Likes: "hiking", MaxDistance: 2.5}, codes.InvalidArgument},
+		{"NaNDistance", &pb.FindSimilarUsersRequest{Likes: "hiking", MaxDistance: float32(math.NaN())}, codes.InvalidArgument},
+		{"BadPageToken", &pb.FindSimilarUsersRequest{Likes: "hiking", PageToken: "garbage"}, codes.InvalidArgument},
+		{"OtherUser", &pb.FindSimilarUsersRequest{UserId: "2222222222"}, codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.FindSimilarUsers(ctx, tt.req)
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+
+	// Likes without any words have no embedding and match nobody.
+	resp, err := svc.FindSimilarUsers(ctx, &pb.FindSimilarUsersRequest{Likes: "?!"})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Users)
+}
+
+func TestCompletePage(t *testing.T) {
+	users := func(distances ...float64) []*pb.SimilarUser {
+		result := make([]*pb.SimilarUser, len(distances))
+		for i, d := range distances {
+			result[i] = &pb.SimilarUser{UserId: string(rune('a' + i)), Distance: d}
+		}
+		return result
+	}
+	ids := func(users []*pb.SimilarUser) string {
+		var b strings.Builder
+		for _, u := range users {
+			b.WriteString(u.UserId)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name  string
+		users []*pb.SimilarUser
+		want  string
+	}{
+		{"NoTies", users(0.1, 0.2, 0.3), "ab"},
+		{"TieInsidePage", users(0.1, 0.1, 0.3), "ab"},
+		// c may not be the smallest ID at 0.3, so it waits for the next page.
+		{"TieAtBoundary", users(0.1, 0.2, 0.3, 0.3), "ab"},
+		{"PageEndsInTie", users(0.1, 0.3, 0.3), "a"},
+		{"AllTied", users(0.3, 0.3, 0.3), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := len(tt.users) - 1
+			assert.Equal(t, tt.want, ids(completePage(tt.users, limit)))
+		})
+	}
+}
+
+func TestUserService_FindSimilarUsers_Ties(t *testing.T) {
+	distances := map[string]float64{"a": 0.5, "b": 0.5, "c": 0.5, "d": 0.5, "e": 0.75}
+	float8 := func(p []byte) float64 { return math.Float64frombits(binary.BigEndian.Uint64(p)) }
+	bigint := func(p []byte) int { return int(binary.BigEndian.Uint64(p)) }
+	type row struct {
+		id       string
+		distance float64
+	}
+	result := func(rows []row, limit int) [][]any {
+		var out [][]any
+		for _, r := range rows[:min(limit, len(rows))] {
+			out = append(out, []any{r.id, "user-" + r.id, r.distance})
+		}
+		return out
+	}
+	columns := []dbtest.Column{
+		{Name: "id", OID: pgtype.TextOID},
+		{Name: "username", OID: pgtype.TextOID},
+		{Name: "distance", OID: pgtype.Float8OID},
+	}
+
+	srv := dbtest.NewServer(t)
+	srv.HandleVector()
+	srv.HandleTransactions()
+	// The exact tie lookup orders by ID.
+	srv.Handle("like_embedding <=> $1::vector = $3 AND id > $4", dbtest.Handler{
+		ParamOIDs: []uint32{dbtest.VectorOID, pgtype.TextOID, pgtype.Float8OID, pgtype.TextOID, pgtype.Int8OID},
+		Columns:   columns,
+		Rows: func(q dbtest.Query) [][]any {
+			var rows []row
+			for id, d := range distances {
+				if d == float8(q.Params[2]) && id > string(q.Params[3]) {
+					rows = append(rows, row{id, d})
+				}
+			}
+			slices.SortFunc(rows, func(a, b row) int { return strings.Compare(a.id, b.id) })
+			return result(rows, bigint(q.Params[4]))
+		},
+	})
+	// The index scan only orders by distance; ties come back in the least helpful order.
+	srv.Handle("ORDER BY like_embedding <=> $1::vector LIMIT $6", dbtest.Handler{
+		ParamOIDs: []uint32{dbtest.VectorOID, pgtype.TextOID, pgtype.Float8OID, pgtype.Float8OID, pgtype.TextOID, pgtype.Int8OID},
+		Columns:   columns,
+		Rows: func(q dbtest.Query) [][]any {
+			var rows []row
+			for id, d := range distances {
+				if q.Params[3] != nil {
+					after := float8(q.Params[3])
+					if d < after || (d == after && id <= string(q.Params[4])) {
+						continue
+					}
+				}
+				rows = append(rows, row{id, d})
+			}
+			slices.SortFunc(rows, func(a, b row) int {
+				if c := cmp.Compare(a.distance, b.distance); c != 0 {
+					return c
+				}
+				return strings.Compare(b.id, a.id)
+			})
+			return result(rows, bigint(q.Params[5]))
+		},
+	})
+	pool, err := db.NewPostgresClient(&config.Config{Postgres: srv.Config()})
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	svc := &UserService{db: pool, logger: zap.NewNop(), embedder: NewHashingEmbedder(8),
+		cfg: &config.Config{SimilarUsers: config.SimilarUsersConfig{DefaultLimit: 2, MaxLimit: 2}}}
+	ctx := context.WithValue(context.Background(), interceptor.UserIDKey, "me")
+
+	var got []string
+	req := &pb.FindSimilarUsersRequest{Likes: "hiking"}
+	for range len(distances) + 1 {
+		resp, err := svc.FindSimilarUsers(ctx, req)
+		require.NoError(t, err)
+		for _, u := range resp.Users {
+			got = append(got, u.UserId)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	// Every user appears exactly once, ordered by distance and then by ID.
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, got)
+	var tieLookups int
+	for _, q := range srv.Queries() {
+		if strings.Contains(q.SQL, "ORDER BY id") {
+			tieLookups++
+		}
+	}
+	assert.Equal(t, 1, tieLookups, "the first page is all ties and needs the exact lookup")
+}
@@ -1,65 +1,508 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
 	"io"
+	"mime"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"tx/internal/config"
+	"tx/internal/interceptor"
 	pb "tx/proto/gen"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
+// SendFile 响应头和响应尾中的元数据键
+const (
+	FileSizeHeader       = "x-file-size"      // 文件总大小（字节）
+	FileModTimeHeader    = "x-file-mtime"     // 文件修改时间（RFC3339）
+	FileMIMETypeHeader   = "x-file-mime-type" // 文件MIME类型
+	FileSHA256Header     = "x-file-sha256"    // 整个文件的SHA-256摘要（十六进制）
+	ContentLengthTrailer = "x-content-length" // 本次实际发送的字节数
+	ContentSHA256Trailer = "x-content-sha256" // 本次实际发送内容的SHA-256摘要（十六进制）
+)
+
+const (
+	minChunkSize      = 4 * 1024        // 文件块最小大小
+	defaultChunkSize  = 1024 * 1024     // 未配置时的文件块大小
+	defaultMaxMsgSize = 4 * 1024 * 1024 // 未配置时的gRPC最大消息大小，与gRPC默认值一致
+	chunkOverhead     = 1024            // FileChunk中除内容外其他字段的预留空间
+)
+
 // SystemService 实现系统服务
 type SystemService struct {
 	pb.UnimplementedSystemServiceServer
-	logger *zap.Logger
-	cfg    *config.Config
+	logger  *zap.Logger
+	cfg     *config.Config
+	roots   fileRoots
+	limiter *transferLimiter
+	digests *digestCache // 整个文件的摘要，用于x-file-sha256响应头
 }
 
 // NewSystemService 创建系统服务
 func NewSystemService(logger *zap.Logger, cfg *config.Config) *SystemService {
 	return &SystemService{
-		logger: logger,
-		cfg:    cfg,
+		logger:  logger,
+		cfg:     cfg,
+		roots:   newFileRoots(cfg.System.FileRoots, logger),
+		limiter: newTransferLimiter(cfg.System.Transfer),
+		digests: newDigestCache(maxCachedDigests),
 	}
 }
 
-// SendFile 实现文件流式传输
+// SendFile 实现文件流式传输，支持从指定偏移续传以及按字节区间读取
 func (s *SystemService) SendFile(req *pb.SendFileRequest, stream pb.SystemService_SendFileServer) error {
+	// 限制每个用户的并发传输数
+	userID := transferUser(stream.Context())
+	quota, err := s.limiter.acquire(userID)
+	if err != nil {
+		s.logger.Warn("Rejected file transfer", zap.String("user_id", userID), zap.Error(err))
+		return err
+	}
+	defer s.limiter.release(userID, quota)
+
+	// 将请求路径限制在配置的根目录之内
+	path, err := s.roots.resolveFile(req.FilePath)
+	if err != nil {
+		s.logger.Warn("Rejected file path", zap.String("path", req.FilePath), zap.Error(err))
+		return err
+	}
+
 	// 打开文件
-	file, err := os.Open(req.FilePath)
+	file, err := os.Open(path)
 	if err != nil {
-		s.logger.Error("Failed to open file", zap.String("path", req.FilePath), zap.Error(err))
-		return status.Errorf(codes.Internal, "failed to open file: %v", err)
+		s.logger.Error("Failed to open file", zap.String("path", path), zap.Error(err))
+		return openError(err)
 	}
 	defer file.Close()
 
-	// 缓冲区大小为1MB
-	buffer := make([]byte, 1024*1024)
+	info, err := file.Stat()
+	if err != nil {
+		s.logger.Error("Failed to stat file", zap.String("path", req.FilePath), zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to stat file: %v", err)
+	}
+
+	// 计算需要发送的字节区间
+	ranges, err := resolveRanges(req, info.Size())
+	if err != nil {
+		return err
+	}
+
+	// 从缓冲区池获取本次传输使用的块缓冲区
+	chunkSize := s.chunkSize(req.ChunkSize)
+	buffer := chunkBuffers.get(chunkSize)
+	defer chunkBuffers.put(buffer)
+
+	// 在发送内容前通过响应头告知文件元数据
+	ctx := stream.Context()
+	md, err := s.fileMetadata(ctx, file, path, info, *buffer)
+	if err != nil {
+		if cerr := contextError(ctx); cerr != nil {
+			s.logger.Warn("File transfer canceled", zap.String("path", path), zap.Error(cerr))
+			return cerr
+		}
+		s.logger.Error("Failed to read file metadata", zap.String("path", path), zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to read file metadata: %v", err)
+	}
+	if err := stream.SendHeader(md); err != nil {
+		s.logger.Error("Error sending file metadata", zap.Error(err))
+		return status.Errorf(codes.Internal, "error sending file metadata: %v", err)
+	}
+
+	t := &transfer{
+		ctx:    ctx,
+		quota:  quota,
+		stream: stream,
+		file:   file,
+		buffer: *buffer,
+		digest: sha256.New(),
+
+		compression: negotiateCompression(req.AcceptedCompressions),
+	}
+	if t.compression != pb.Compression_COMPRESSION_NONE {
+		compressed := chunkBuffers.get(chunkSize)
+		defer func() {
+			*compressed = t.compressed
+			chunkBuffers.put(compressed)
+		}()
+		t.compressed = *compressed
+	}
+	var expected int64
+	for _, r := range ranges {
+		expected += r.Length
+	}
+	for _, r := range ranges {
+		if err = s.sendRange(t, r); err != nil {
+			break
+		}
+	}
+
+	// 在链路追踪中记录实际发送的字节数，传输中断时同样记录
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("file.path", req.FilePath),
+		attribute.Int64("file.bytes_expected", expected),
+		attribute.Int64("file.bytes_sent", t.sent),
+	)
+	if err != nil {
+		span.RecordError(err)
+		s.logger.Warn("File transfer aborted", zap.String("path", req.FilePath),
+			zap.Int64("bytes_sent", t.sent), zap.Int64("bytes_expected", expected), zap.Error(err))
+		return err
+	}
+
+	// 通过响应尾告知实际发送内容的摘要，供客户端校验
+	stream.SetTrailer(metadata.Pairs(
+		ContentLengthTrailer, strconv.FormatInt(t.sent, 10),
+		ContentSHA256Trailer, hex.EncodeToString(t.digest.Sum(nil)),
+	))
+	s.logger.Info("File sent successfully", zap.String("path", req.FilePath),
+		zap.Int("ranges", len(ranges)), zap.Int64("bytes", t.sent), zap.Int("chunk_size", chunkSize))
+	return nil
+}
+
+// transfer 记录一次文件发送的状态
+type transfer struct {
+	ctx    context.Context
+	quota  *userQuota
+	stream pb.SystemService_SendFileServer
+	file   *os.File
+	buffer []byte
+	digest hash.Hash // 已发送内容的摘要
+	sent   int64     // 已发送的字节数（未压缩）
+
+	compression pb.Compression // 与客户端协商的压缩算法
+	compressed  []byte         // 压缩结果的复用缓冲区
+}
 
+// sendRange 按块发送文件中的一个字节区间，每块都携带其在文件中的偏移
+func (s *SystemService) sendRange(t *transfer, r *pb.ByteRange) error {
+	section := io.NewSectionReader(t.file, r.Offset, r.Length)
+	offset := r.Offset
 	for {
+		// 客户端取消或超过截止时间后不再继续读取文件
+		if err := contextError(t.ctx); err != nil {
+			return err
+		}
+
 		// 读取文件内容
-		n, err := file.Read(buffer)
+		n, err := section.Read(t.buffer)
+		if n > 0 {
+			content, compression, err := compressChunk(t.compression, t.compressed, t.buffer[:n])
+			if err != nil {
+				s.logger.Error("Error compressing file chunk", zap.Error(err))
+				return status.Errorf(codes.Internal, "error compressing file chunk: %v", err)
+			}
+			if compression != pb.Compression_COMPRESSION_NONE {
+				t.compressed = content[:0]
+			}
+			// 按实际发送的字节数限流
+			if err := s.limiter.wait(t.ctx, t.quota, len(content)); err != nil {
+				return err
+			}
+			if err := t.stream.Send(&pb.FileChunk{
+				Content:     content,
+				Offset:      offset,
+				Compression: compression,
+			}); err != nil {
+				if cerr := contextError(t.ctx); cerr != nil {
+					return cerr
+				}
+				s.logger.Error("Error sending file chunk", zap.Error(err))
+				return status.Errorf(codes.Internal, "error sending file chunk: %v", err)
+			}
+			t.digest.Write(t.buffer[:n])
+			t.sent += int64(n)
+			offset += int64(n)
+		}
 		if err == io.EOF {
-			s.logger.Info("File read completed", zap.String("path", req.FilePath))
-			// EOF表示文件读取完成
-			break
+			// EOF表示区间读取完成
+			s.logger.Debug("File range read completed",
+				zap.String("path", t.file.Name()), zap.Int64("offset", r.Offset), zap.Int64("length", r.Length))
+			return nil
 		}
 		if err != nil {
 			s.logger.Error("Error reading file", zap.Error(err))
 			return status.Errorf(codes.Internal, "error reading file: %v", err)
 		}
+	}
+}
+
+// fileMetadata 生成描述整个文件的响应头：大小、修改时间、MIME类型和SHA-256摘要
+// 摘要在文件未修改时取自缓存，buffer用作读取文件的临时缓冲区，计算摘要的过程可以通过ctx取消
+func (s *SystemService) fileMetadata(ctx context.Context, file *os.File, path string, info os.FileInfo, buffer []byte) (metadata.MD, error) {
+	mimeType := mime.TypeByExtension(filepath.Ext(info.Name()))
+	if mimeType == "" {
+		// 读取文件头部用于内容类型探测
+		head := buffer[:min(len(buffer), 512)]
+		n, err := file.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		mimeType = http.DetectContentType(head[:n])
+	}
 
-		if err := stream.Send(&pb.FileChunk{
-			Content: buffer[:n],
-		}); err != nil {
-			s.logger.Error("Error sending file chunk", zap.Error(err))
-			return status.Errorf(codes.Internal, "error sending file chunk: %v", err)
+	sum, err := s.digests.fileDigest(ctx, file, path, info, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata.Pairs(
+		FileSizeHeader, strconv.FormatInt(info.Size(), 10),
+		FileModTimeHeader, info.ModTime().UTC().Format(time.RFC3339Nano),
+		FileMIMETypeHeader, mimeType,
+		FileSHA256Header, sum,
+	), nil
+}
+
+// UploadFile 实现文件上传（客户端流式传输）
+// 第一条消息为文件头，之后按顺序接收文件块；内容先写入同目录下的临时文件，
+// 大小和摘要校验通过后再原子移动到目标位置，文件头未设置overwrite时不替换已有文件
+func (s *SystemService) UploadFile(stream pb.SystemService_UploadFileServer) error {
+	userID := transferUser(stream.Context())
+	quota, err := s.limiter.acquire(userID)
+	if err != nil {
+		s.logger.Warn("Rejected file transfer", zap.String("user_id", userID), zap.Error(err))
+		return err
+	}
+	defer s.limiter.release(userID, quota)
+
+	first, err := stream.Recv()
+	if err != nil {
+		s.logger.Error("Error receiving upload header", zap.Error(err))
+		return status.Errorf(codes.InvalidArgument, "error receiving upload header: %v", err)
+	}
+	header := first.GetHeader()
+	if header == nil {
+		return status.Error(codes.InvalidArgument, "first message must be an upload header")
+	}
+	if header.Size < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid file size %d", header.Size)
+	}
+	if limit := s.cfg.System.MaxUploadBytes; limit > 0 && header.Size > limit {
+		return status.Errorf(codes.ResourceExhausted, "upload of %d bytes exceeds the limit of %d bytes", header.Size, limit)
+	}
+
+	target, err := s.roots.resolveTarget(header.FileName)
+	if err != nil {
+		s.logger.Warn("Rejected upload path", zap.String("path", header.FileName), zap.Error(err))
+		return err
+	}
+	// 在接收内容之前先检查一次，避免传完才发现目标已存在；提交时仍以不替换的方式创建
+	if !header.Overwrite {
+		if _, err := os.Lstat(target); err == nil {
+			return status.Errorf(codes.AlreadyExists, "file %q already exists", header.FileName)
 		}
 	}
-	s.logger.Info("File sent successfully", zap.String("path", req.FilePath))
+
+	// 临时文件与目标位于同一目录，保证rename是原子操作
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".upload-*")
+	if err != nil {
+		s.logger.Error("Failed to create temp file", zap.String("path", target), zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to create temp file: %v", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	hash := sha256.New()
+	var written int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if cerr := contextError(stream.Context()); cerr != nil {
+				s.logger.Warn("File upload canceled", zap.String("path", header.FileName),
+					zap.Int64("bytes_received", written), zap.Error(cerr))
+				return cerr
+			}
+			s.logger.Error("Error receiving file chunk", zap.Error(err))
+			return status.Errorf(codes.Internal, "error receiving file chunk: %v", err)
+		}
+		chunk := req.GetChunk()
+		if chunk == nil {
+			return status.Error(codes.InvalidArgument, "upload header can only be sent once")
+		}
+		// 按接收的字节数限流，延迟读取下一块以对客户端形成背压
+		if err := s.limiter.wait(stream.Context(), quota, len(chunk.Content)); err != nil {
+			return err
+		}
+		if chunk.Offset != written {
+			return status.Errorf(codes.InvalidArgument, "chunk offset %d does not match received bytes %d", chunk.Offset, written)
+		}
+		// 文件头中的大小由客户端声明，解压时最多读到大小上限，再按实际接收的字节数检查，超过时临时文件由defer删除
+		limit := header.Size - written
+		if s.cfg.System.MaxUploadBytes > 0 {
+			limit = s.cfg.System.MaxUploadBytes - written
+		}
+		content, err := decompressChunk(chunk.Compression, chunk.Content, limit)
+		if errors.Is(err, errChunkTooLarge) && s.cfg.System.MaxUploadBytes > 0 {
+			s.logger.Warn("Rejected oversized upload", zap.String("path", header.FileName), zap.Error(err))
+			return status.Errorf(codes.ResourceExhausted, "upload exceeds the limit of %d bytes", s.cfg.System.MaxUploadBytes)
+		}
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid file chunk at offset %d: %v", chunk.Offset, err)
+		}
+		if int64(len(content)) > header.Size-written {
+			return status.Errorf(codes.InvalidArgument, "received more than the declared %d bytes", header.Size)
+		}
+		if _, err := tmp.Write(content); err != nil {
+			s.logger.Error("Error writing file chunk", zap.Error(err))
+			return status.Errorf(codes.Internal, "error writing file chunk: %v", err)
+		}
+		hash.Write(content)
+		written += int64(len(content))
+	}
+
+	if written != header.Size {
+		return status.Errorf(codes.InvalidArgument, "received %d bytes, expected %d", written, header.Size)
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if header.Sha256 != "" && !strings.EqualFold(header.Sha256, digest) {
+		s.logger.Warn("Upload checksum mismatch", zap.String("path", header.FileName),
+			zap.String("expected", header.Sha256), zap.String("actual", digest))
+		return status.Errorf(codes.DataLoss, "checksum mismatch: expected %s, got %s", header.Sha256, digest)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return status.Errorf(codes.Internal, "failed to flush file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return status.Errorf(codes.Internal, "failed to close file: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return status.Errorf(codes.Internal, "failed to set file mode: %v", err)
+	}
+	if err := commitUpload(tmp.Name(), target, header.Overwrite); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return status.Errorf(codes.AlreadyExists, "file %q already exists", header.FileName)
+		}
+		s.logger.Error("Failed to store uploaded file", zap.String("path", target), zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to store file: %v", err)
+	}
+	committed = true
+
+	s.logger.Info("File uploaded successfully", zap.String("path", target),
+		zap.Int64("size", written), zap.String("sha256", digest))
+	return stream.SendAndClose(&pb.UploadFileResponse{
+		FileName: header.FileName,
+		Size:     written,
+		Sha256:   digest,
+	})
+}
+
+// commitUpload 将临时文件移动到目标位置
+// overwrite为false时使用硬链接，目标已存在（包括上传期间被其他请求创建）时返回os.ErrExist，不会替换已有文件
+func commitUpload(tmp, target string, overwrite bool) error {
+	if overwrite {
+		return os.Rename(tmp, target)
+	}
+	if err := os.Link(tmp, target); err != nil {
+		return err
+	}
+	// 目标已经指向上传的内容，临时文件名删除失败只会留下一个隐藏文件
+	os.Remove(tmp)
 	return nil
 }
+
+// chunkSize 计算本次传输的块大小
+// 优先使用客户端提示，否则使用配置的默认值，并限制在最小块大小和gRPC最大消息大小之间
+func (s *SystemService) chunkSize(hint int32) int {
+	size := s.cfg.System.ChunkSize
+	if hint > 0 {
+		size = int(hint)
+	}
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	maxMsgSize := s.cfg.GRPC.MaxMessageSize
+	if maxMsgSize <= 0 {
+		maxMsgSize = defaultMaxMsgSize
+	}
+	return max(minChunkSize, min(size, maxMsgSize-chunkOverhead))
+}
+
+// contextReader 在每次读取前检查上下文，使耗时的读取可以被取消
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// contextError 上下文已取消或超时时返回对应的gRPC状态（Canceled或DeadlineExceeded），否则返回nil
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return nil
+}
+
+// transferUser 返回发起传输的用户ID，未认证的调用共享同一个空ID
+func transferUser(ctx context.Context) string {
+	userID, _ := ctx.Value(interceptor.UserIDKey).(string)
+	return userID
+}
+
+// openError 将打开文件的错误转换为gRPC状态
+func openError(err error) error {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return status.Errorf(codes.NotFound, "failed to open file: %v", err)
+	case errors.Is(err, os.ErrPermission):
+		return status.Errorf(codes.PermissionDenied, "failed to open file: %v", err)
+	default:
+		return status.Errorf(codes.Internal, "failed to open file: %v", err)
+	}
+}
+
+// resolveRanges 根据请求和文件大小计算实际需要发送的字节区间
+// 未指定ranges时使用offset/length，长度为0或超出文件末尾的区间截断到文件末尾
+func resolveRanges(req *pb.SendFileRequest, size int64) ([]*pb.ByteRange, error) {
+	requested := req.Ranges
+	if len(requested) == 0 {
+		requested = []*pb.ByteRange{{Offset: req.Offset, Length: req.Length}}
+	}
+
+	ranges := make([]*pb.ByteRange, 0, len(requested))
+	for _, r := range requested {
+		if r.Offset < 0 || r.Length < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid range: offset=%d length=%d", r.Offset, r.Length)
+		}
+		if r.Offset > size {
+			return nil, status.Errorf(codes.OutOfRange, "offset %d exceeds file size %d", r.Offset, size)
+		}
+		length := size - r.Offset
+		if r.Length > 0 && r.Length < length {
+			length = r.Length
+		}
+		ranges = append(ranges, &pb.ByteRange{Offset: r.Offset, Length: length})
+	}
+	return ranges, nil
+}
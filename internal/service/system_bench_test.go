@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tx/internal/config"
+	pb "tx/proto/gen"
+
+	"go.uber.org/zap"
+)
+
+// discardSendFileServer counts sent bytes without copying them, so the
+// benchmark measures the server side of SendFile only.
+type discardSendFileServer struct {
+	mockSystem_SendFileServer
+	received int64
+}
+
+func (d *discardSendFileServer) Send(chunk *pb.FileChunk) error {
+	d.received += int64(len(chunk.Content))
+	return nil
+}
+
+func BenchmarkSystemService_SendFile(b *testing.B) {
+	root := b.TempDir()
+	files := []struct {
+		name string
+		size int
+	}{
+		{"small", 4 * 1024},
+		{"medium", 1024 * 1024},
+		{"large", 64 * 1024 * 1024},
+	}
+	for _, f := range files {
+		content := make([]byte, f.size)
+		for i := range content {
+			content[i] = byte(i % 251)
+		}
+		if err := os.WriteFile(filepath.Join(root, f.name), content, 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}}}
+	service := NewSystemService(zap.NewNop(), cfg)
+
+	for _, f := range files {
+		for _, chunkSize := range []int32{64 * 1024, 1024 * 1024} {
+			b.Run(fmt.Sprintf("%s/chunk=%dKB", f.name, chunkSize/1024), func(b *testing.B) {
+				req := &pb.SendFileRequest{FilePath: f.name, ChunkSize: chunkSize}
+				b.SetBytes(int64(f.size))
+				b.ReportAllocs()
+				for b.Loop() {
+					stream := &discardSendFileServer{}
+					if err := service.SendFile(req, stream); err != nil {
+						b.Fatal(err)
+					}
+					if stream.received != int64(f.size) {
+						b.Fatalf("received %d bytes, want %d", stream.received, f.size)
+					}
+				}
+			})
+		}
+	}
+}
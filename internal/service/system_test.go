@@ -3,30 +3,47 @@ package service
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"tx/internal/config"
+	"tx/internal/interceptor"
 	pb "tx/proto/gen" // Assuming this is the correct path to your generated protobuf code
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 // mockSystem_SendFileServer is a mock implementation of pb.SystemService_SendFileServer
 // It needs to satisfy the grpc.ServerStream interface as well.
 type mockSystem_SendFileServer struct {
-	SentChunks [][]byte
-	errOnSend  error
-	header     metadata.MD
-	trailer    metadata.MD
-	customCtx  context.Context
+	mu               sync.Mutex // guards the sent chunks while a streaming call runs in another goroutine
+	SentChunks       [][]byte
+	SentOffsets      []int64
+	SentCompressions []pb.Compression
+	errOnSend        error
+	header           metadata.MD
+	trailer          metadata.MD
+	customCtx        context.Context
+	afterSend        func() // called after every successful Send, e.g. to cancel customCtx mid-transfer
 	// Explicitly not embedding grpc.ServerStream to ensure all methods are consciously implemented.
 }
 
@@ -35,10 +52,17 @@ func (m *mockSystem_SendFileServer) Send(chunk *pb.FileChunk) error {
 	if m.errOnSend != nil {
 		return m.errOnSend
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	// Copy the content as the buffer in the SUT might be reused.
 	contentCopy := make([]byte, len(chunk.Content))
 	copy(contentCopy, chunk.Content)
 	m.SentChunks = append(m.SentChunks, contentCopy)
+	m.SentOffsets = append(m.SentOffsets, chunk.Offset)
+	m.SentCompressions = append(m.SentCompressions, chunk.Compression)
+	if m.afterSend != nil {
+		m.afterSend()
+	}
 	return nil
 }
 
@@ -83,15 +107,30 @@ func (m *mockSystem_SendFileServer) RecvMsg(v interface{}) error {
 	return io.EOF
 }
 
+// received returns the concatenation of every chunk sent so far; safe to call concurrently with Send.
+func (m *mockSystem_SendFileServer) received() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return string(bytes.Join(m.SentChunks, nil))
+}
+
+// reassemble writes every sent chunk at its reported offset into dst.
+func (m *mockSystem_SendFileServer) reassemble(dst []byte) {
+	for i, chunk := range m.SentChunks {
+		copy(dst[m.SentOffsets[i]:], chunk)
+	}
+}
+
 func TestSystemService_SendFile(t *testing.T) {
 	logger := zap.NewNop() // Use Nop logger for cleaner test output
-	cfg := &config.Config{}
+	// Use t.TempDir() as the only served root to ensure automatic cleanup of its contents
+	root := t.TempDir()
+	cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}}}
 
 	// Helper to create a temporary file with given content
 	createTempFile := func(t *testing.T, content []byte) string {
 		t.Helper()
-		// Use t.TempDir() to ensure automatic cleanup of the directory and its contents
-		tmpFile, err := os.CreateTemp(t.TempDir(), "testfile-*.txt")
+		tmpFile, err := os.CreateTemp(root, "testfile-*.txt")
 		require.NoError(t, err)
 		if len(content) > 0 {
 			_, err = tmpFile.Write(content)
@@ -165,8 +204,244 @@ func TestSystemService_SendFile(t *testing.T) {
 
 		st, ok := status.FromError(err)
 		require.True(t, ok, "Error should be a gRPC status error")
-		assert.Equal(t, codes.Internal, st.Code())
-		assert.Contains(t, st.Message(), "failed to open file")
+		assert.Equal(t, codes.NotFound, st.Code())
+		assert.Contains(t, st.Message(), "not found")
+	})
+
+	t.Run("path relative to file root", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		fileContent := []byte("served by relative path")
+		filePath := createTempFile(t, fileContent)
+
+		mockStream := &mockSystem_SendFileServer{}
+		req := &pb.SendFileRequest{FilePath: filepath.Base(filePath)}
+
+		err := service.SendFile(req, mockStream)
+		require.NoError(t, err)
+		require.Len(t, mockStream.SentChunks, 1)
+		assert.Equal(t, fileContent, mockStream.SentChunks[0])
+	})
+
+	t.Run("paths outside file roots are rejected", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		filePath := createTempFile(t, []byte("inside"))
+
+		outside := t.TempDir()
+		secret := filepath.Join(outside, "secret.txt")
+		require.NoError(t, os.WriteFile(secret, []byte("top secret"), 0o600))
+		require.NoError(t, os.Symlink(secret, filepath.Join(root, "escape-file")))
+		require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape-dir")))
+		require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0o755))
+
+		cases := []struct {
+			name string
+			path string
+			code codes.Code
+		}{
+			{"parent traversal", "../" + filepath.Base(outside) + "/secret.txt", codes.PermissionDenied},
+			{"traversal hidden inside path", filepath.Base(filePath) + "/../../secret.txt", codes.PermissionDenied},
+			{"absolute path outside roots", secret, codes.PermissionDenied},
+			{"absolute system file", "/etc/passwd", codes.PermissionDenied},
+			{"symlinked file escaping root", "escape-file", codes.PermissionDenied},
+			{"symlinked directory escaping root", "escape-dir/secret.txt", codes.PermissionDenied},
+			{"missing file inside root", "missing.txt", codes.NotFound},
+			{"empty path", "", codes.InvalidArgument},
+			{"file root itself", ".", codes.InvalidArgument},
+			{"directory inside root", "dir/", codes.InvalidArgument},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				mockStream := &mockSystem_SendFileServer{}
+				err := service.SendFile(&pb.SendFileRequest{FilePath: tc.path}, mockStream)
+				require.Error(t, err)
+				assert.Equal(t, tc.code, status.Code(err))
+				assert.Empty(t, mockStream.SentChunks)
+			})
+		}
+	})
+
+	t.Run("no file roots configured", func(t *testing.T) {
+		service := NewSystemService(logger, &config.Config{})
+		filePath := createTempFile(t, []byte("unreachable"))
+
+		err := service.SendFile(&pb.SendFileRequest{FilePath: filePath}, &mockSystem_SendFileServer{})
+		require.Error(t, err)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	})
+
+	t.Run("resume interrupted transfer from offset", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		fileContent := make([]byte, 2*1024*1024+300)
+		for i := range fileContent {
+			fileContent[i] = byte(i % 251)
+		}
+		filePath := createTempFile(t, fileContent)
+
+		// First transfer is "interrupted" after the first 1MB + 17 bytes.
+		received := int64(1024*1024 + 17)
+		first := &mockSystem_SendFileServer{}
+		err := service.SendFile(&pb.SendFileRequest{FilePath: filePath, Length: received}, first)
+		require.NoError(t, err)
+
+		// Resume from where the first transfer stopped.
+		resumed := &mockSystem_SendFileServer{}
+		err = service.SendFile(&pb.SendFileRequest{FilePath: filePath, Offset: received}, resumed)
+		require.NoError(t, err)
+		require.NotEmpty(t, resumed.SentOffsets)
+		assert.Equal(t, received, resumed.SentOffsets[0], "Resumed transfer should start at the requested offset")
+
+		reassembled := make([]byte, len(fileContent))
+		first.reassemble(reassembled)
+		resumed.reassemble(reassembled)
+		assert.Equal(t, fileContent, reassembled, "Reassembled content should match original")
+	})
+
+	t.Run("multiple byte ranges", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		fileContent := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+		filePath := createTempFile(t, fileContent)
+
+		mockStream := &mockSystem_SendFileServer{}
+		req := &pb.SendFileRequest{
+			FilePath: filePath,
+			Ranges: []*pb.ByteRange{
+				{Offset: 30, Length: 0}, // to EOF
+				{Offset: 0, Length: 4},
+				{Offset: 10, Length: 100}, // clamped to EOF
+			},
+		}
+
+		err := service.SendFile(req, mockStream)
+		require.NoError(t, err)
+
+		require.Len(t, mockStream.SentChunks, 3)
+		assert.Equal(t, []int64{30, 0, 10}, mockStream.SentOffsets)
+		assert.Equal(t, []byte("uvwxyz"), mockStream.SentChunks[0])
+		assert.Equal(t, []byte("0123"), mockStream.SentChunks[1])
+		assert.Equal(t, fileContent[10:], mockStream.SentChunks[2])
+	})
+
+	t.Run("resume at end of file sends nothing", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		fileContent := []byte("already fully downloaded")
+		filePath := createTempFile(t, fileContent)
+
+		mockStream := &mockSystem_SendFileServer{}
+		req := &pb.SendFileRequest{FilePath: filePath, Offset: int64(len(fileContent))}
+
+		err := service.SendFile(req, mockStream)
+		require.NoError(t, err)
+		assert.Empty(t, mockStream.SentChunks)
+	})
+
+	t.Run("invalid ranges", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		filePath := createTempFile(t, []byte("short"))
+
+		cases := []struct {
+			name string
+			req  *pb.SendFileRequest
+			code codes.Code
+		}{
+			{"negative offset", &pb.SendFileRequest{FilePath: filePath, Offset: -1}, codes.InvalidArgument},
+			{"negative length", &pb.SendFileRequest{FilePath: filePath, Length: -5}, codes.InvalidArgument},
+			{"offset past end", &pb.SendFileRequest{FilePath: filePath, Offset: 6}, codes.OutOfRange},
+			{"range past end", &pb.SendFileRequest{FilePath: filePath, Ranges: []*pb.ByteRange{{Offset: 0}, {Offset: 100}}}, codes.OutOfRange},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				mockStream := &mockSystem_SendFileServer{}
+				err := service.SendFile(tc.req, mockStream)
+				require.Error(t, err)
+				assert.Equal(t, tc.code, status.Code(err))
+				assert.Empty(t, mockStream.SentChunks, "Nothing should be sent for an invalid request")
+			})
+		}
+	})
+
+	t.Run("file metadata and content digest", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		fileContent := make([]byte, 1024*1024+512)
+		for i := range fileContent {
+			fileContent[i] = byte(i % 199)
+		}
+		filePath := createTempFile(t, fileContent)
+		modTime := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+		require.NoError(t, os.Chtimes(filePath, modTime, modTime))
+		fileSum := sha256.Sum256(fileContent)
+
+		mockStream := &mockSystem_SendFileServer{}
+		err := service.SendFile(&pb.SendFileRequest{FilePath: filePath}, mockStream)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{strconv.Itoa(len(fileContent))}, mockStream.header.Get(FileSizeHeader))
+		assert.Equal(t, []string{modTime.Format(time.RFC3339Nano)}, mockStream.header.Get(FileModTimeHeader))
+		assert.Equal(t, []string{"text/plain; charset=utf-8"}, mockStream.header.Get(FileMIMETypeHeader))
+		assert.Equal(t, []string{hex.EncodeToString(fileSum[:])}, mockStream.header.Get(FileSHA256Header))
+
+		// The trailer digest must match the bytes that were actually received.
+		var received bytes.Buffer
+		for _, chunk := range mockStream.SentChunks {
+			received.Write(chunk)
+		}
+		receivedSum := sha256.Sum256(received.Bytes())
+		assert.Equal(t, []string{hex.EncodeToString(receivedSum[:])}, mockStream.trailer.Get(ContentSHA256Trailer))
+		assert.Equal(t, []string{strconv.Itoa(received.Len())}, mockStream.trailer.Get(ContentLengthTrailer))
+		assert.Equal(t, mockStream.header.Get(FileSHA256Header), mockStream.trailer.Get(ContentSHA256Trailer))
+	})
+
+	t.Run("range transfer digest covers only sent bytes", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		fileContent := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+		filePath := createTempFile(t, fileContent)
+
+		mockStream := &mockSystem_SendFileServer{}
+		err := service.SendFile(&pb.SendFileRequest{FilePath: filePath, Offset: 10, Length: 5}, mockStream)
+		require.NoError(t, err)
+
+		fileSum := sha256.Sum256(fileContent)
+		rangeSum := sha256.Sum256(fileContent[10:15])
+		assert.Equal(t, []string{hex.EncodeToString(fileSum[:])}, mockStream.header.Get(FileSHA256Header))
+		assert.Equal(t, []string{hex.EncodeToString(rangeSum[:])}, mockStream.trailer.Get(ContentSHA256Trailer))
+		assert.Equal(t, []string{"5"}, mockStream.trailer.Get(ContentLengthTrailer))
+	})
+
+	t.Run("resumed transfer reuses the cached file digest", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		fileContent := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+		filePath := createTempFile(t, fileContent)
+		info, err := os.Stat(filePath)
+		require.NoError(t, err)
+		fileSum := sha256.Sum256(fileContent)
+
+		first := &mockSystem_SendFileServer{}
+		require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: filePath}, first))
+		assert.Equal(t, []string{hex.EncodeToString(fileSum[:])}, first.header.Get(FileSHA256Header))
+
+		// Same size and modification time: the header comes from the cache, not from reading the file.
+		require.NoError(t, os.WriteFile(filePath, bytes.ToUpper(fileContent), 0o644))
+		require.NoError(t, os.Chtimes(filePath, info.ModTime(), info.ModTime()))
+		resumed := &mockSystem_SendFileServer{}
+		require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: filePath, Offset: 30}, resumed))
+		assert.Equal(t, first.header.Get(FileSHA256Header), resumed.header.Get(FileSHA256Header))
+
+		// A modified file is hashed again.
+		changed := append(fileContent, '!')
+		require.NoError(t, os.WriteFile(filePath, changed, 0o644))
+		changedSum := sha256.Sum256(changed)
+		after := &mockSystem_SendFileServer{}
+		require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: filePath, Offset: 30}, after))
+		assert.Equal(t, []string{hex.EncodeToString(changedSum[:])}, after.header.Get(FileSHA256Header))
+	})
+
+	t.Run("mime type detected from content", func(t *testing.T) {
+		service := NewSystemService(logger, cfg)
+		filePath := filepath.Join(root, "image-without-extension")
+		require.NoError(t, os.WriteFile(filePath, []byte("\x89PNG\r\n\x1a\n rest of image"), 0o644))
+
+		mockStream := &mockSystem_SendFileServer{}
+		require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: filePath}, mockStream))
+		assert.Equal(t, []string{"image/png"}, mockStream.header.Get(FileMIMETypeHeader))
 	})
 
 	t.Run("error on stream send", func(t *testing.T) {
@@ -188,3 +463,686 @@ func TestSystemService_SendFile(t *testing.T) {
 		assert.Contains(t, st.Message(), expectedErr.Error()) // Check if original error is part of the message
 	})
 }
+
+// mockSystem_UploadFileServer is a mock implementation of pb.SystemService_UploadFileServer.
+type mockSystem_UploadFileServer struct {
+	Requests  []*pb.UploadFileRequest
+	Response  *pb.UploadFileResponse
+	errOnRecv error
+	onRecv    func(next int) // called before each Recv with the index of the request about to be returned
+	next      int
+}
+
+// Recv returns the queued requests in order, then io.EOF.
+func (m *mockSystem_UploadFileServer) Recv() (*pb.UploadFileRequest, error) {
+	if m.onRecv != nil {
+		m.onRecv(m.next)
+	}
+	if m.errOnRecv != nil && m.next > 0 {
+		return nil, m.errOnRecv
+	}
+	if m.next >= len(m.Requests) {
+		return nil, io.EOF
+	}
+	req := m.Requests[m.next]
+	m.next++
+	return req, nil
+}
+
+// SendAndClose stores the response.
+func (m *mockSystem_UploadFileServer) SendAndClose(resp *pb.UploadFileResponse) error {
+	m.Response = resp
+	return nil
+}
+
+func (m *mockSystem_UploadFileServer) SetHeader(metadata.MD) error  { return nil }
+func (m *mockSystem_UploadFileServer) SendHeader(metadata.MD) error { return nil }
+func (m *mockSystem_UploadFileServer) SetTrailer(metadata.MD)       {}
+func (m *mockSystem_UploadFileServer) Context() context.Context     { return context.Background() }
+func (m *mockSystem_UploadFileServer) SendMsg(interface{}) error    { return nil }
+
+// RecvMsg is part of grpc.ServerStream and delegates to Recv.
+func (m *mockSystem_UploadFileServer) RecvMsg(v interface{}) error {
+	req, err := m.Recv()
+	if err != nil {
+		return err
+	}
+	proto.Merge(v.(*pb.UploadFileRequest), req)
+	return nil
+}
+
+// uploadRequests builds a header followed by chunks of at most chunkSize bytes.
+func uploadRequests(name string, content []byte, checksum string, chunkSize int) []*pb.UploadFileRequest {
+	reqs := []*pb.UploadFileRequest{{
+		Payload: &pb.UploadFileRequest_Header{Header: &pb.UploadFileHeader{
+			FileName: name,
+			Size:     int64(len(content)),
+			Sha256:   checksum,
+		}},
+	}}
+	for off := 0; off < len(content); off += chunkSize {
+		end := min(off+chunkSize, len(content))
+		reqs = append(reqs, &pb.UploadFileRequest{
+			Payload: &pb.UploadFileRequest_Chunk{Chunk: &pb.FileChunk{Content: content[off:end], Offset: int64(off)}},
+		})
+	}
+	return reqs
+}
+
+func TestSystemService_UploadFile(t *testing.T) {
+	logger := zap.NewNop()
+
+	newService := func(t *testing.T) (*SystemService, string) {
+		t.Helper()
+		root := t.TempDir()
+		cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}}}
+		return NewSystemService(logger, cfg), root
+	}
+
+	// listDir returns the names in dir so tests can assert no temp files are left behind.
+	listDir := func(t *testing.T, dir string) []string {
+		t.Helper()
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	content := bytes.Repeat([]byte("upload me please "), 1000)
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	t.Run("successful upload", func(t *testing.T) {
+		service, root := newService(t)
+		stream := &mockSystem_UploadFileServer{Requests: uploadRequests("uploaded.txt", content, checksum, 4096)}
+
+		err := service.UploadFile(stream)
+		require.NoError(t, err)
+
+		require.NotNil(t, stream.Response)
+		assert.Equal(t, int64(len(content)), stream.Response.Size)
+		assert.Equal(t, checksum, stream.Response.Sha256)
+
+		stored, err := os.ReadFile(filepath.Join(root, "uploaded.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, content, stored)
+		assert.Equal(t, []string{"uploaded.txt"}, listDir(t, root))
+
+		// The stored file can be downloaded again through SendFile.
+		download := &mockSystem_SendFileServer{}
+		require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: "uploaded.txt"}, download))
+		reassembled := make([]byte, len(content))
+		download.reassemble(reassembled)
+		assert.Equal(t, content, reassembled)
+	})
+
+	t.Run("existing file is kept unless overwrite is set", func(t *testing.T) {
+		service, root := newService(t)
+		existing := filepath.Join(root, "existing.txt")
+		require.NoError(t, os.WriteFile(existing, []byte("old"), 0o644))
+
+		stream := &mockSystem_UploadFileServer{Requests: uploadRequests("existing.txt", []byte("new content"), "", 4)}
+		err := service.UploadFile(stream)
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+		stored, err := os.ReadFile(existing)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("old"), stored)
+
+		requests := uploadRequests("existing.txt", []byte("new content"), "", 4)
+		requests[0].GetHeader().Overwrite = true
+		require.NoError(t, service.UploadFile(&mockSystem_UploadFileServer{Requests: requests}))
+		stored, err = os.ReadFile(existing)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("new content"), stored)
+		assert.Equal(t, []string{"existing.txt"}, listDir(t, root))
+	})
+
+	t.Run("file created during upload is not replaced", func(t *testing.T) {
+		service, root := newService(t)
+		requests := uploadRequests("race.txt", content, "", 4096)
+		// Another client creates the target after the header has been checked.
+		stream := &mockSystem_UploadFileServer{Requests: requests, onRecv: func(next int) {
+			if next == 2 {
+				require.NoError(t, os.WriteFile(filepath.Join(root, "race.txt"), []byte("winner"), 0o644))
+			}
+		}}
+
+		err := service.UploadFile(stream)
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+		stored, err := os.ReadFile(filepath.Join(root, "race.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("winner"), stored)
+		assert.Equal(t, []string{"race.txt"}, listDir(t, root))
+	})
+
+	t.Run("rejected uploads leave no files behind", func(t *testing.T) {
+		chunkOnly := uploadRequests("x.txt", content, "", 4096)[1:]
+		doubleHeader := append(uploadRequests("x.txt", content, "", 4096), uploadRequests("x.txt", nil, "", 1)[0])
+		outOfOrder := uploadRequests("x.txt", content, "", 4096)
+		outOfOrder[1], outOfOrder[2] = outOfOrder[2], outOfOrder[1]
+		truncated := uploadRequests("x.txt", content, "", 4096)
+		truncated = truncated[:len(truncated)-1]
+		oversized := uploadRequests("x.txt", content, "", 4096)
+		oversized[0].GetHeader().Size = 10
+
+		cases := []struct {
+			name     string
+			requests []*pb.UploadFileRequest
+			code     codes.Code
+		}{
+			{"checksum mismatch", uploadRequests("x.txt", content, strings.Repeat("0", 64), 4096), codes.DataLoss},
+			{"missing header", chunkOnly, codes.InvalidArgument},
+			{"header sent twice", doubleHeader, codes.InvalidArgument},
+			{"chunks out of order", outOfOrder, codes.InvalidArgument},
+			{"fewer bytes than declared", truncated, codes.InvalidArgument},
+			{"more bytes than declared", oversized, codes.InvalidArgument},
+			{"path traversal", uploadRequests("../x.txt", content, "", 4096), codes.PermissionDenied},
+			{"absolute path outside root", uploadRequests("/tmp/x.txt", content, "", 4096), codes.PermissionDenied},
+			{"missing directory", uploadRequests("no/such/dir/x.txt", content, "", 4096), codes.NotFound},
+		}
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				service, root := newService(t)
+				stream := &mockSystem_UploadFileServer{Requests: tc.requests}
+
+				err := service.UploadFile(stream)
+				require.Error(t, err)
+				assert.Equal(t, tc.code, status.Code(err))
+				assert.Nil(t, stream.Response)
+				assert.Empty(t, listDir(t, root))
+			})
+		}
+	})
+
+	t.Run("uploads over max_upload_bytes are aborted", func(t *testing.T) {
+		root := t.TempDir()
+		cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}, MaxUploadBytes: 8 * 1024}}
+		service := NewSystemService(logger, cfg)
+
+		// A client that understates the size is stopped by the bytes actually received.
+		understated := uploadRequests("big.txt", content, "", 16*1024)
+		understated[0].GetHeader().Size = 1024
+		var sawTemp bool
+		stream := &mockSystem_UploadFileServer{Requests: understated, onRecv: func(next int) {
+			sawTemp = sawTemp || len(listDir(t, root)) > 0
+		}}
+		err := service.UploadFile(stream)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.True(t, sawTemp, "the upload was streaming into a temp file")
+		assert.Empty(t, listDir(t, root))
+
+		// A declared size over the limit is rejected before anything is written.
+		stream = &mockSystem_UploadFileServer{Requests: uploadRequests("big.txt", content, "", 4096)}
+		err = service.UploadFile(stream)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, 1, stream.next, "only the header was read")
+		assert.Empty(t, listDir(t, root))
+
+		require.NoError(t, service.UploadFile(&mockSystem_UploadFileServer{Requests: uploadRequests("small.txt", content[:8*1024], "", 4096)}))
+		assert.Equal(t, []string{"small.txt"}, listDir(t, root))
+	})
+
+	t.Run("stream error aborts upload", func(t *testing.T) {
+		service, root := newService(t)
+		stream := &mockSystem_UploadFileServer{
+			Requests:  uploadRequests("x.txt", content, "", 4096),
+			errOnRecv: errors.New("connection reset"),
+		}
+
+		err := service.UploadFile(stream)
+		require.Error(t, err)
+		assert.Equal(t, codes.Internal, status.Code(err))
+		assert.Empty(t, listDir(t, root))
+	})
+}
+
+func TestSystemService_SendFileCompression(t *testing.T) {
+	logger := zap.NewNop()
+	root := t.TempDir()
+	cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}}}
+	service := NewSystemService(logger, cfg)
+
+	// Highly compressible, log-like content spanning several chunks.
+	var logContent bytes.Buffer
+	for i := 0; logContent.Len() < 3*1024*1024; i++ {
+		fmt.Fprintf(&logContent, "2024-05-01T12:00:%02d INFO request handled path=/api/v1/items/%d status=200\n", i%60, i%1000)
+	}
+	logContent.Truncate(3 * 1024 * 1024) // whole chunks only, so every chunk is worth compressing
+	logPath := filepath.Join(root, "app.log")
+	require.NoError(t, os.WriteFile(logPath, logContent.Bytes(), 0o644))
+
+	// Random content does not compress and must be sent raw.
+	randomContent := make([]byte, 256*1024)
+	_, err := rand.Read(randomContent)
+	require.NoError(t, err)
+	randomPath := filepath.Join(root, "random.bin")
+	require.NoError(t, os.WriteFile(randomPath, randomContent, 0o644))
+
+	// download fetches a file and decodes every chunk back to raw bytes.
+	download := func(t *testing.T, req *pb.SendFileRequest, size int) (*mockSystem_SendFileServer, []pb.Compression, []byte) {
+		t.Helper()
+		mockStream := &mockSystem_SendFileServer{}
+		require.NoError(t, service.SendFile(req, mockStream))
+
+		decoded := make([]byte, size)
+		var used []pb.Compression
+		for i, chunk := range mockStream.SentChunks {
+			used = append(used, mockStream.SentCompressions[i])
+			raw, err := decompressChunk(mockStream.SentCompressions[i], chunk, int64(size))
+			require.NoError(t, err)
+			copy(decoded[mockStream.SentOffsets[i]:], raw)
+		}
+		return mockStream, used, decoded
+	}
+
+	plain, _, plainContent := download(t, &pb.SendFileRequest{FilePath: "app.log"}, logContent.Len())
+	require.Equal(t, logContent.Bytes(), plainContent)
+
+	for _, codec := range []pb.Compression{pb.Compression_COMPRESSION_GZIP, pb.Compression_COMPRESSION_ZSTD} {
+		t.Run(codec.String(), func(t *testing.T) {
+			req := &pb.SendFileRequest{FilePath: "app.log", AcceptedCompressions: []pb.Compression{codec}}
+			compressed, used, content := download(t, req, logContent.Len())
+
+			assert.Equal(t, plainContent, content, "Round trip must match the uncompressed path")
+			assert.Equal(t, plain.SentOffsets, compressed.SentOffsets, "Offsets refer to uncompressed positions")
+			for _, c := range used {
+				assert.Equal(t, codec, c)
+			}
+
+			var wire int
+			for _, chunk := range compressed.SentChunks {
+				wire += len(chunk)
+			}
+			assert.Less(t, wire*5, logContent.Len(), "Log content should compress well")
+
+			// Digests always describe the uncompressed content.
+			assert.Equal(t, plain.header.Get(FileSHA256Header), compressed.header.Get(FileSHA256Header))
+			assert.Equal(t, plain.trailer.Get(ContentSHA256Trailer), compressed.trailer.Get(ContentSHA256Trailer))
+		})
+	}
+
+	t.Run("client preference order", func(t *testing.T) {
+		req := &pb.SendFileRequest{
+			FilePath:             "app.log",
+			AcceptedCompressions: []pb.Compression{pb.Compression(99), pb.Compression_COMPRESSION_ZSTD, pb.Compression_COMPRESSION_GZIP},
+		}
+		_, used, content := download(t, req, logContent.Len())
+		assert.Equal(t, plainContent, content)
+		assert.Equal(t, pb.Compression_COMPRESSION_ZSTD, used[0], "Unknown codecs are skipped")
+	})
+
+	t.Run("incompressible chunks are sent raw", func(t *testing.T) {
+		req := &pb.SendFileRequest{FilePath: "random.bin", AcceptedCompressions: []pb.Compression{pb.Compression_COMPRESSION_GZIP}}
+		mockStream, used, content := download(t, req, len(randomContent))
+		assert.Equal(t, randomContent, content)
+		assert.Equal(t, []pb.Compression{pb.Compression_COMPRESSION_NONE}, used)
+		assert.Equal(t, randomContent, mockStream.SentChunks[0])
+	})
+
+	t.Run("compressed upload", func(t *testing.T) {
+		reqs := uploadRequests("compressed.log", logContent.Bytes(), "", 64*1024)
+		for _, r := range reqs[1:] {
+			chunk := r.GetChunk()
+			content, codec, err := compressChunk(pb.Compression_COMPRESSION_ZSTD, nil, chunk.Content)
+			require.NoError(t, err)
+			chunk.Content, chunk.Compression = content, codec
+		}
+		stream := &mockSystem_UploadFileServer{Requests: reqs}
+		require.NoError(t, service.UploadFile(stream))
+
+		stored, err := os.ReadFile(filepath.Join(root, "compressed.log"))
+		require.NoError(t, err)
+		assert.Equal(t, logContent.Bytes(), stored)
+	})
+
+	t.Run("compressed upload larger than declared size", func(t *testing.T) {
+		reqs := uploadRequests("bomb.log", logContent.Bytes(), "", logContent.Len())
+		reqs[0].GetHeader().Size = 1024
+		content, codec, err := compressChunk(pb.Compression_COMPRESSION_GZIP, nil, reqs[1].GetChunk().Content)
+		require.NoError(t, err)
+		reqs[1].GetChunk().Content, reqs[1].GetChunk().Compression = content, codec
+
+		err = service.UploadFile(&mockSystem_UploadFileServer{Requests: reqs})
+		require.Error(t, err)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.NoFileExists(t, filepath.Join(root, "bomb.log"))
+	})
+}
+
+func TestSystemService_TransferLimits(t *testing.T) {
+	logger := zap.NewNop()
+	root := t.TempDir()
+	filePath := filepath.Join(root, "limited.bin")
+	require.NoError(t, os.WriteFile(filePath, bytes.Repeat([]byte{0xAB}, 15*1024), 0o644))
+
+	userCtx := func(userID string) context.Context {
+		return context.WithValue(context.Background(), interceptor.UserIDKey, userID)
+	}
+
+	t.Run("concurrent streams per user", func(t *testing.T) {
+		cfg := &config.Config{System: config.SystemConfig{
+			FileRoots: []string{root},
+			Transfer:  config.TransferConfig{MaxStreamsPerUser: 1},
+		}}
+		service := NewSystemService(logger, cfg)
+
+		// Simulate a transfer already in progress for alice.
+		quota, err := service.limiter.acquire("alice")
+		require.NoError(t, err)
+
+		err = service.SendFile(&pb.SendFileRequest{FilePath: "limited.bin"}, &mockSystem_SendFileServer{customCtx: userCtx("alice")})
+		require.Error(t, err)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		err = service.UploadFile(&mockSystem_UploadFileServer{Requests: uploadRequests("up.bin", []byte("x"), "", 1)})
+		require.NoError(t, err, "Other users are not affected")
+		err = service.SendFile(&pb.SendFileRequest{FilePath: "limited.bin"}, &mockSystem_SendFileServer{customCtx: userCtx("bob")})
+		require.NoError(t, err, "Other users are not affected")
+
+		service.limiter.release("alice", quota)
+		err = service.SendFile(&pb.SendFileRequest{FilePath: "limited.bin"}, &mockSystem_SendFileServer{customCtx: userCtx("alice")})
+		require.NoError(t, err, "Slot is available again after release")
+		assert.Contains(t, service.limiter.users, "alice", "The quota outlives the transfer")
+	})
+
+	t.Run("idle users are cleaned up", func(t *testing.T) {
+		cfg := &config.Config{System: config.SystemConfig{
+			FileRoots: []string{root},
+			Transfer:  config.TransferConfig{UserBytesPerSecond: 1024},
+		}}
+		service := NewSystemService(logger, cfg)
+		now := time.Now()
+		service.limiter.now = func() time.Time { return now }
+
+		quota, err := service.limiter.acquire("alice")
+		require.NoError(t, err)
+		service.limiter.release("alice", quota)
+
+		now = now.Add(userQuotaIdle / 2)
+		quota, err = service.limiter.acquire("bob")
+		require.NoError(t, err)
+		assert.Contains(t, service.limiter.users, "alice", "Alice's bucket may not have refilled yet")
+
+		now = now.Add(userQuotaIdle)
+		service.limiter.release("bob", quota)
+		_, err = service.limiter.acquire("carol")
+		require.NoError(t, err)
+		assert.NotContains(t, service.limiter.users, "alice")
+		assert.Contains(t, service.limiter.users, "bob", "Bob finished too recently")
+	})
+
+	t.Run("bandwidth caps", func(t *testing.T) {
+		for _, transfer := range []config.TransferConfig{
+			{GlobalBytesPerSecond: 10 * 1024},
+			{UserBytesPerSecond: 10 * 1024},
+		} {
+			cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}, Transfer: transfer}}
+			service := NewSystemService(logger, cfg)
+
+			// The bucket starts with one second of burst (10KB); the remaining 5KB take ~0.5s.
+			start := time.Now()
+			mockStream := &mockSystem_SendFileServer{customCtx: userCtx("alice")}
+			require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: "limited.bin"}, mockStream))
+			assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+			require.Len(t, mockStream.SentChunks, 1)
+			assert.Len(t, mockStream.SentChunks[0], 15*1024)
+		}
+	})
+
+	t.Run("back-to-back transfers share the user's bucket", func(t *testing.T) {
+		cfg := &config.Config{System: config.SystemConfig{
+			FileRoots: []string{root},
+			Transfer:  config.TransferConfig{UserBytesPerSecond: 20 * 1024},
+		}}
+		service := NewSystemService(logger, cfg)
+
+		// 30KB in two transfers against a 20KB burst: the second one waits ~0.5s for the
+		// missing 10KB instead of starting with a fresh bucket.
+		start := time.Now()
+		for range 2 {
+			mockStream := &mockSystem_SendFileServer{customCtx: userCtx("alice")}
+			require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: "limited.bin"}, mockStream))
+		}
+		assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	})
+
+	t.Run("throttled transfer gives up at deadline", func(t *testing.T) {
+		cfg := &config.Config{System: config.SystemConfig{
+			FileRoots: []string{root},
+			Transfer:  config.TransferConfig{UserBytesPerSecond: 1024},
+		}}
+		service := NewSystemService(logger, cfg)
+
+		ctx, cancel := context.WithTimeout(userCtx("alice"), 100*time.Millisecond)
+		defer cancel()
+		mockStream := &mockSystem_SendFileServer{customCtx: ctx}
+		err := service.SendFile(&pb.SendFileRequest{FilePath: "limited.bin"}, mockStream)
+		require.Error(t, err)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+		assert.Empty(t, mockStream.SentChunks)
+	})
+}
+
+func TestSystemService_ChunkSize(t *testing.T) {
+	cases := []struct {
+		name       string
+		configured int
+		maxMsgSize int
+		hint       int32
+		want       int
+	}{
+		{"defaults", 0, 0, 0, defaultChunkSize},
+		{"configured default", 256 * 1024, 0, 0, 256 * 1024},
+		{"client hint wins", 256 * 1024, 0, 64 * 1024, 64 * 1024},
+		{"hint clamped to max message size", 0, 0, 16 * 1024 * 1024, defaultMaxMsgSize - chunkOverhead},
+		{"configured max message size", 0, 8 * 1024 * 1024, 6 * 1024 * 1024, 6 * 1024 * 1024},
+		{"config clamped to max message size", 2 * 1024 * 1024, 1024 * 1024, 0, 1024*1024 - chunkOverhead},
+		{"hint raised to minimum", 0, 0, 10, minChunkSize},
+		{"negative hint ignored", 128 * 1024, 0, -1, 128 * 1024},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &config.Config{
+				GRPC:   config.GRPCConfig{MaxMessageSize: tc.maxMsgSize},
+				System: config.SystemConfig{ChunkSize: tc.configured},
+			}
+			service := NewSystemService(zap.NewNop(), cfg)
+			assert.Equal(t, tc.want, service.chunkSize(tc.hint))
+		})
+	}
+
+	t.Run("transfer uses negotiated chunk size", func(t *testing.T) {
+		root := t.TempDir()
+		fileContent := bytes.Repeat([]byte("0123456789abcdef"), 10*1024) // 160KB
+		require.NoError(t, os.WriteFile(filepath.Join(root, "chunked.bin"), fileContent, 0o644))
+		cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}, ChunkSize: 64 * 1024}}
+		service := NewSystemService(zap.NewNop(), cfg)
+
+		mockStream := &mockSystem_SendFileServer{}
+		require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: "chunked.bin"}, mockStream))
+		assert.Equal(t, []int64{0, 64 * 1024, 128 * 1024}, mockStream.SentOffsets)
+
+		mockStream = &mockSystem_SendFileServer{}
+		require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: "chunked.bin", ChunkSize: 100 * 1024}, mockStream))
+		assert.Equal(t, []int64{0, 100 * 1024}, mockStream.SentOffsets)
+
+		reassembled := make([]byte, len(fileContent))
+		mockStream.reassemble(reassembled)
+		assert.Equal(t, fileContent, reassembled)
+	})
+}
+
+func TestBufferPool(t *testing.T) {
+	var pool bufferPool
+
+	b := pool.get(1000)
+	assert.Len(t, *b, 1000)
+	assert.Equal(t, 1024, cap(*b), "Capacity is rounded up to a power of two")
+	pool.put(b)
+
+	// Buffers whose capacity grew are filed under the class they can still serve.
+	grown := make([]byte, 0, 3000)
+	pool.put(&grown)
+	for _, size := range []int{1, 2, 1024, 2048, 4096} {
+		got := pool.get(size)
+		assert.Len(t, *got, size)
+		assert.GreaterOrEqual(t, cap(*got), size)
+	}
+}
+
+func TestSystemService_SendFileCancellation(t *testing.T) {
+	root := t.TempDir()
+	fileContent := bytes.Repeat([]byte("cancel me "), 400*1024) // ~4MB, several chunks
+	require.NoError(t, os.WriteFile(filepath.Join(root, "big.bin"), fileContent, 0o644))
+	cfg := &config.Config{System: config.SystemConfig{FileRoots: []string{root}}}
+	service := NewSystemService(zap.NewNop(), cfg)
+
+	// tracedContext returns a context carrying a recording span, as the tracer interceptor would.
+	tracedContext := func(t *testing.T, parent context.Context) (context.Context, func() sdktrace.ReadOnlySpan) {
+		t.Helper()
+		recorder := tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		ctx, span := provider.Tracer("test").Start(parent, "SendFile")
+		return ctx, func() sdktrace.ReadOnlySpan {
+			span.End()
+			ended := recorder.Ended()
+			require.Len(t, ended, 1)
+			return ended[0]
+		}
+	}
+	spanAttr := func(span sdktrace.ReadOnlySpan, key string) int64 {
+		for _, kv := range span.Attributes() {
+			if string(kv.Key) == key {
+				return kv.Value.AsInt64()
+			}
+		}
+		t.Fatalf("span attribute %q not found", key)
+		return 0
+	}
+
+	t.Run("canceled before start", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		mockStream := &mockSystem_SendFileServer{customCtx: ctx}
+
+		err := service.SendFile(&pb.SendFileRequest{FilePath: "big.bin"}, mockStream)
+		require.Error(t, err)
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		assert.Empty(t, mockStream.SentChunks)
+	})
+
+	t.Run("deadline already exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		mockStream := &mockSystem_SendFileServer{customCtx: ctx}
+
+		err := service.SendFile(&pb.SendFileRequest{FilePath: "big.bin"}, mockStream)
+		require.Error(t, err)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+		assert.Empty(t, mockStream.SentChunks)
+	})
+
+	t.Run("canceled mid-transfer", func(t *testing.T) {
+		parent, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ctx, endSpan := tracedContext(t, parent)
+		mockStream := &mockSystem_SendFileServer{customCtx: ctx}
+		mockStream.afterSend = func() {
+			if len(mockStream.SentChunks) == 2 {
+				cancel()
+			}
+		}
+
+		err := service.SendFile(&pb.SendFileRequest{FilePath: "big.bin"}, mockStream)
+		require.Error(t, err)
+		assert.Equal(t, codes.Canceled, status.Code(err))
+		require.Len(t, mockStream.SentChunks, 2, "Transfer stops right after cancellation")
+		assert.Empty(t, mockStream.trailer, "No completion digest for an aborted transfer")
+
+		span := endSpan()
+		assert.Equal(t, int64(2*defaultChunkSize), spanAttr(span, "file.bytes_sent"))
+		assert.Equal(t, int64(len(fileContent)), spanAttr(span, "file.bytes_expected"))
+	})
+
+	t.Run("deadline hit mid-transfer", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		ctx, endSpan := tracedContext(t, ctx)
+		mockStream := &mockSystem_SendFileServer{customCtx: &expiringContext{Context: ctx}}
+		mockStream.afterSend = func() { mockStream.customCtx.(*expiringContext).expired = true }
+
+		err := service.SendFile(&pb.SendFileRequest{FilePath: "big.bin", Offset: 100}, mockStream)
+		require.Error(t, err)
+		assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+		require.Len(t, mockStream.SentChunks, 1)
+
+		span := endSpan()
+		assert.Equal(t, int64(defaultChunkSize), spanAttr(span, "file.bytes_sent"))
+		assert.Equal(t, int64(len(fileContent)-100), spanAttr(span, "file.bytes_expected"))
+	})
+
+	t.Run("completed transfer records bytes on span", func(t *testing.T) {
+		ctx, endSpan := tracedContext(t, context.Background())
+		mockStream := &mockSystem_SendFileServer{customCtx: ctx}
+
+		require.NoError(t, service.SendFile(&pb.SendFileRequest{FilePath: "big.bin"}, mockStream))
+		span := endSpan()
+		assert.Equal(t, int64(len(fileContent)), spanAttr(span, "file.bytes_sent"))
+	})
+}
+
+// expiringContext reports context.DeadlineExceeded once expired is set, letting
+// tests hit a deadline at an exact point in the transfer.
+type expiringContext struct {
+	context.Context
+	expired bool
+}
+
+func (c *expiringContext) Err() error {
+	if c.expired {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
+
+func TestDigestCache(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cache := newDigestCache(1)
+	digest := func(t *testing.T, path string) string {
+		t.Helper()
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+		info, err := file.Stat()
+		require.NoError(t, err)
+		sum, err := cache.fileDigest(ctx, file, path, info, make([]byte, 8))
+		require.NoError(t, err)
+		return sum
+	}
+
+	a, b := filepath.Join(dir, "a"), filepath.Join(dir, "b")
+	require.NoError(t, os.WriteFile(a, []byte("first file"), 0o644))
+	require.NoError(t, os.WriteFile(b, []byte("second file"), 0o644))
+	want := sha256.Sum256([]byte("first file"))
+	assert.Equal(t, hex.EncodeToString(want[:]), digest(t, a))
+	_, ok := cache.get(a, mustStat(t, a))
+	assert.True(t, ok)
+
+	// The limit is one entry, so caching b evicts a.
+	digest(t, b)
+	_, ok = cache.get(a, mustStat(t, a))
+	assert.False(t, ok)
+	_, ok = cache.get(b, mustStat(t, b))
+	assert.True(t, ok)
+}
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info
+}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"crypto/sha256"
+	"io"
+	"os"
+	"time"
+
+	pb "tx/proto/gen"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultTailPollInterval 未配置时TailFile检查文件变化的间隔
+const defaultTailPollInterval = 500 * time.Millisecond
+
+// TailFile 先发送文件末尾的若干行，然后持续发送追加的内容，直到客户端取消
+// 文件被截断时从头开始发送；路径指向新文件（日志轮转）时先发完旧文件剩余内容再切换到新文件
+func (s *SystemService) TailFile(req *pb.TailFileRequest, stream pb.SystemService_TailFileServer) error {
+	if req.Lines < 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid line count %d", req.Lines)
+	}
+
+	userID := transferUser(stream.Context())
+	quota, err := s.limiter.acquire(userID)
+	if err != nil {
+		s.logger.Warn("Rejected file transfer", zap.String("user_id", userID), zap.Error(err))
+		return err
+	}
+	defer s.limiter.release(userID, quota)
+
+	file, info, err := s.openTail(req.FilePath)
+	if err != nil {
+		s.logger.Warn("Rejected file path", zap.String("path", req.FilePath), zap.Error(err))
+		return err
+	}
+
+	chunkSize := s.chunkSize(0)
+	buffer := chunkBuffers.get(chunkSize)
+	defer chunkBuffers.put(buffer)
+
+	t := &transfer{
+		ctx:    stream.Context(),
+		quota:  quota,
+		stream: stream,
+		file:   file,
+		buffer: *buffer,
+		digest: sha256.New(),
+
+		compression: negotiateCompression(req.AcceptedCompressions),
+	}
+	// t.file在轮转时会被替换，关闭最后打开的文件
+	defer func() { t.file.Close() }()
+	if t.compression != pb.Compression_COMPRESSION_NONE {
+		compressed := chunkBuffers.get(chunkSize)
+		defer func() {
+			*compressed = t.compressed
+			chunkBuffers.put(compressed)
+		}()
+		t.compressed = *compressed
+	}
+
+	offset, err := tailOffset(file, info.Size(), int(req.Lines), t.buffer)
+	if err != nil {
+		s.logger.Error("Error reading file", zap.String("path", req.FilePath), zap.Error(err))
+		return status.Errorf(codes.Internal, "error reading file: %v", err)
+	}
+	s.logger.Info("Tailing file", zap.String("path", req.FilePath), zap.Int64("offset", offset))
+
+	interval := s.cfg.System.TailPollInterval
+	if interval <= 0 {
+		interval = defaultTailPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// 发送当前文件中新增的内容
+		info, err := t.file.Stat()
+		if err != nil {
+			s.logger.Error("Failed to stat file", zap.String("path", req.FilePath), zap.Error(err))
+			return status.Errorf(codes.Internal, "failed to stat file: %v", err)
+		}
+		if info.Size() < offset {
+			s.logger.Info("File truncated, restarting from beginning", zap.String("path", req.FilePath))
+			offset = 0
+		}
+		if info.Size() > offset {
+			if err := s.sendRange(t, &pb.ByteRange{Offset: offset, Length: info.Size() - offset}); err != nil {
+				s.logger.Info("Stopped tailing file", zap.String("path", req.FilePath),
+					zap.Int64("bytes_sent", t.sent), zap.Error(err))
+				return err
+			}
+			offset = info.Size()
+		}
+
+		// 路径指向了另一个文件说明发生了轮转
+		if next, nextInfo, err := s.openTail(req.FilePath); err == nil {
+			if os.SameFile(info, nextInfo) {
+				next.Close()
+			} else {
+				// 上次Stat之后写入旧文件的内容在切换前发送，否则会丢失
+				if err := s.drainTail(t, offset); err != nil {
+					next.Close()
+					s.logger.Info("Stopped tailing file", zap.String("path", req.FilePath),
+						zap.Int64("bytes_sent", t.sent), zap.Error(err))
+					return err
+				}
+				s.logger.Info("File rotated, following new file", zap.String("path", req.FilePath))
+				t.file.Close()
+				t.file, offset = next, 0
+				continue
+			}
+		}
+
+		select {
+		case <-t.ctx.Done():
+			s.logger.Info("Stopped tailing file", zap.String("path", req.FilePath),
+				zap.Int64("bytes_sent", t.sent), zap.Error(t.ctx.Err()))
+			return contextError(t.ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// drainTail 发送轮转前的旧文件从offset到当前末尾的内容
+func (s *SystemService) drainTail(t *transfer, offset int64) error {
+	info, err := t.file.Stat()
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to stat file: %v", err)
+	}
+	if info.Size() <= offset {
+		return nil
+	}
+	return s.sendRange(t, &pb.ByteRange{Offset: offset, Length: info.Size() - offset})
+}
+
+// openTail 在文件根目录内打开需要跟踪的文件
+// 轮转检查时也会调用，因此每次都重新解析路径，保证新文件同样位于根目录之内
+func (s *SystemService) openTail(requested string) (*os.File, os.FileInfo, error) {
+	path, err := s.roots.resolveFile(requested)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, openError(err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, status.Errorf(codes.Internal, "failed to stat file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, status.Errorf(codes.InvalidArgument, "%q is not a regular file", requested)
+	}
+	return file, info, nil
+}
+
+// tailOffset 返回文件最后n行的起始偏移，从文件末尾向前按块查找换行符
+// 文件以换行符结尾时，最后的换行符不算作新的一行
+func tailOffset(file *os.File, size int64, n int, buffer []byte) (int64, error) {
+	if n == 0 {
+		return size, nil
+	}
+	newlines := 0
+	for pos := size; pos > 0; {
+		readSize := min(int64(len(buffer)), pos)
+		pos -= readSize
+		block := buffer[:readSize]
+		if _, err := file.ReadAt(block, pos); err != nil && err != io.EOF {
+			return 0, err
+		}
+		for i := len(block) - 1; i >= 0; i-- {
+			if block[i] != '\n' || pos+int64(i) == size-1 {
+				continue
+			}
+			newlines++
+			if newlines == n {
+				return pos + int64(i) + 1, nil
+			}
+		}
+	}
+	return 0, nil
+}
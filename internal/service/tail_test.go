@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+	"tx/pkg/logger"
+	pb "tx/proto/gen"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSystemService_TailFile(t *testing.T) {
+	root := t.TempDir()
+	cfg := &config.Config{System: config.SystemConfig{
+		FileRoots:        []string{root},
+		TailPollInterval: 10 * time.Millisecond,
+	}}
+	service := NewSystemService(zap.NewNop(), cfg)
+
+	// startTail runs TailFile in the background until the returned cancel is called.
+	startTail := func(t *testing.T, req *pb.TailFileRequest) (*mockSystem_SendFileServer, context.CancelFunc, <-chan error) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := &mockSystem_SendFileServer{customCtx: ctx}
+		done := make(chan error, 1)
+		go func() { done <- service.TailFile(req, stream) }()
+		t.Cleanup(cancel)
+		return stream, cancel, done
+	}
+	waitFor := func(t *testing.T, stream *mockSystem_SendFileServer, want string) {
+		t.Helper()
+		require.Eventually(t, func() bool { return stream.received() == want },
+			2*time.Second, 5*time.Millisecond, "got %q, want %q", stream.received(), want)
+	}
+	appendFile := func(t *testing.T, path, content string) {
+		t.Helper()
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = f.WriteString(content)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	t.Run("LastLinesThenAppends", func(t *testing.T) {
+		path := filepath.Join(root, "app.log")
+		require.NoError(t, os.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0o644))
+
+		stream, cancel, done := startTail(t, &pb.TailFileRequest{FilePath: "app.log", Lines: 2})
+		waitFor(t, stream, "three\nfour\n")
+
+		appendFile(t, path, "five\n")
+		waitFor(t, stream, "three\nfour\nfive\n")
+
+		cancel()
+		err := <-done
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	})
+
+	t.Run("MoreLinesThanFile", func(t *testing.T) {
+		path := filepath.Join(root, "short.log")
+		require.NoError(t, os.WriteFile(path, []byte("only\nlines"), 0o644))
+
+		stream, cancel, done := startTail(t, &pb.TailFileRequest{FilePath: "short.log", Lines: 10})
+		waitFor(t, stream, "only\nlines")
+		cancel()
+		<-done
+	})
+
+	t.Run("Truncation", func(t *testing.T) {
+		path := filepath.Join(root, "truncate.log")
+		require.NoError(t, os.WriteFile(path, []byte("a long first line\n"), 0o644))
+
+		stream, cancel, done := startTail(t, &pb.TailFileRequest{FilePath: "truncate.log", Lines: 1})
+		waitFor(t, stream, "a long first line\n")
+
+		require.NoError(t, os.WriteFile(path, []byte("short\n"), 0o644))
+		waitFor(t, stream, "a long first line\nshort\n")
+		cancel()
+		<-done
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		path := filepath.Join(root, "rotate.log")
+		require.NoError(t, os.WriteFile(path, []byte("before\n"), 0o644))
+
+		stream, cancel, done := startTail(t, &pb.TailFileRequest{FilePath: "rotate.log", Lines: 1})
+		waitFor(t, stream, "before\n")
+
+		// Rotate: the writer finishes the old file after it was renamed, then a new file takes its place.
+		old, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		require.NoError(t, err)
+		require.NoError(t, os.Rename(path, path+".1"))
+		_, err = old.WriteString("last old line\n")
+		require.NoError(t, err)
+		require.NoError(t, old.Close())
+		require.NoError(t, os.WriteFile(path, []byte("after\n"), 0o644))
+
+		waitFor(t, stream, "before\nlast old line\nafter\n")
+		appendFile(t, path, "more\n")
+		waitFor(t, stream, "before\nlast old line\nafter\nmore\n")
+		cancel()
+		<-done
+	})
+
+	t.Run("RotationAfterLastPoll", func(t *testing.T) {
+		path := filepath.Join(root, "rotate-late.log")
+		require.NoError(t, os.WriteFile(path, []byte("before\n"), 0o644))
+
+		// The first Send happens after TailFile sized the file and before it checks
+		// for rotation, so the write and rename land exactly in that window.
+		var once sync.Once
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		stream := &mockSystem_SendFileServer{customCtx: ctx}
+		stream.afterSend = func() {
+			once.Do(func() {
+				appendFile(t, path, "written after the poll\n")
+				require.NoError(t, os.Rename(path, path+".1"))
+				require.NoError(t, os.WriteFile(path, []byte("after\n"), 0o644))
+			})
+		}
+		done := make(chan error, 1)
+		go func() {
+			done <- service.TailFile(&pb.TailFileRequest{FilePath: "rotate-late.log", Lines: 1}, stream)
+		}()
+
+		waitFor(t, stream, "before\nwritten after the poll\nafter\n")
+		cancel()
+		<-done
+	})
+
+	t.Run("Compression", func(t *testing.T) {
+		path := filepath.Join(root, "compress.log")
+		line := strings.Repeat("compressible log line ", 100) + "\n"
+		require.NoError(t, os.WriteFile(path, []byte(line), 0o644))
+
+		stream, cancel, done := startTail(t, &pb.TailFileRequest{
+			FilePath:             "compress.log",
+			Lines:                1,
+			AcceptedCompressions: []pb.Compression{pb.Compression_COMPRESSION_GZIP},
+		})
+		require.Eventually(t, func() bool { return stream.received() != "" }, 2*time.Second, 5*time.Millisecond)
+		cancel()
+		<-done
+
+		stream.mu.Lock()
+		defer stream.mu.Unlock()
+		require.Len(t, stream.SentChunks, 1)
+		assert.Equal(t, pb.Compression_COMPRESSION_GZIP, stream.SentCompressions[0])
+		content, err := decompressChunk(stream.SentCompressions[0], stream.SentChunks[0], int64(len(line)))
+		require.NoError(t, err)
+		assert.Equal(t, line, string(content))
+	})
+
+	t.Run("InvalidRequests", func(t *testing.T) {
+		outside := filepath.Join(t.TempDir(), "outside.log")
+		require.NoError(t, os.WriteFile(outside, []byte("secret\n"), 0o644))
+
+		tests := []struct {
+			name string
+			req  *pb.TailFileRequest
+			code codes.Code
+		}{
+			{"NegativeLines", &pb.TailFileRequest{FilePath: "app.log", Lines: -1}, codes.InvalidArgument},
+			{"OutsideRoot", &pb.TailFileRequest{FilePath: outside}, codes.PermissionDenied},
+			{"DotDot", &pb.TailFileRequest{FilePath: "../app.log"}, codes.PermissionDenied},
+			{"Missing", &pb.TailFileRequest{FilePath: "missing.log"}, codes.NotFound},
+			{"Directory", &pb.TailFileRequest{FilePath: "."}, codes.InvalidArgument},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := service.TailFile(tt.req, &mockSystem_SendFileServer{})
+				assert.Equal(t, tt.code, status.Code(err), "unexpected error: %v", err)
+			})
+		}
+	})
+}
+
+func TestSystemService_TailFile_ServiceLog(t *testing.T) {
+	// Operators tail the file NewLogger writes, so its directory must be served
+	// by the default roots, both from the shipped config and from viper defaults.
+	shipped, err := os.ReadFile("../../configs/config.yaml")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"ShippedConfig", string(shipped)},
+		{"Defaults", "grpc:\n  address: \":50051\"\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			t.Cleanup(viper.Reset)
+			t.Chdir(t.TempDir())
+			require.NoError(t, os.Mkdir("configs", 0o755))
+			require.NoError(t, os.WriteFile("configs/config.yaml", []byte(tt.config), 0o644))
+			require.NoError(t, os.Mkdir("data", 0o755))
+
+			cfg, err := config.NewConfig()
+			require.NoError(t, err)
+			assert.NotContains(t, cfg.System.FileRoots, ".", "the working directory holds configs/ and must not be served")
+			log, err := logger.NewLogger()
+			require.NoError(t, err)
+			log.Info("service log line")
+			_ = log.Sync()
+
+			cfg.System.TailPollInterval = 10 * time.Millisecond
+			service := NewSystemService(zap.NewNop(), cfg)
+			ctx, cancel := context.WithCancel(context.Background())
+			stream := &mockSystem_SendFileServer{customCtx: ctx}
+			done := make(chan error, 1)
+			go func() {
+				done <- service.TailFile(&pb.TailFileRequest{FilePath: filepath.Base(logger.FilePath), Lines: 1}, stream)
+			}()
+
+			require.Eventually(t, func() bool { return strings.Contains(stream.received(), "service log line") },
+				2*time.Second, 5*time.Millisecond, "got %q", stream.received())
+			cancel()
+			assert.Equal(t, codes.Canceled, status.Code(<-done))
+
+			err = service.TailFile(&pb.TailFileRequest{FilePath: "configs/config.yaml"}, &mockSystem_SendFileServer{})
+			assert.Equal(t, codes.NotFound, status.Code(err), "configs/ must not be reachable")
+		})
+	}
+}
+
+func TestTailOffset(t *testing.T) {
+	tests := []struct {
+		content string
+		lines   int
+		want    string
+	}{
+		{"one\ntwo\nthree\n", 0, ""},
+		{"one\ntwo\nthree\n", 1, "three\n"},
+		{"one\ntwo\nthree\n", 2, "two\nthree\n"},
+		{"one\ntwo\nthree\n", 5, "one\ntwo\nthree\n"},
+		{"one\ntwo\nthree", 1, "three"},
+		{"one\ntwo\nthree", 2, "two\nthree"},
+		{"one\n\n\n", 2, "\n\n"},
+		{"", 3, ""},
+	}
+	dir := t.TempDir()
+	for i, tt := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+		require.NoError(t, os.WriteFile(path, []byte(tt.content), 0o644))
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		// A 3-byte buffer makes the backwards scan cross block boundaries.
+		for _, size := range []int{3, 4096} {
+			offset, err := tailOffset(f, int64(len(tt.content)), tt.lines, make([]byte, size))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tt.content[offset:], "content %q, lines %d, buffer %d", tt.content, tt.lines, size)
+		}
+		f.Close()
+	}
+}
@@ -2,35 +2,66 @@ package service
 
 import (
 	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"tx/internal/config"
+	"tx/internal/interceptor"
+	"tx/internal/session"
 	"tx/pkg/utils"
 	pb "tx/proto/gen"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // UserService 实现用户服务
 type UserService struct {
 	pb.UnimplementedUserServiceServer
-	db     *pgxpool.Pool
-	redis  *redis.Client
-	logger *zap.Logger
-	cfg    *config.Config
+	db        *pgxpool.Pool
+	redis     *redis.Client
+	logger    *zap.Logger
+	cfg       *config.Config
+	hasher    utils.PasswordHasher
+	usernames *utils.UsernamePolicy // 用户名规则
+	policy    *utils.PasswordPolicy // 密码强度要求
+	jwt       *utils.JWTManager
+	sessions  *session.Store // 刷新令牌和令牌吊销
+	limiter   *session.LoginLimiter
+	embedder  Embedder // 用户喜好embedding
+
+	dummyOnce sync.Once
+	dummyHash string // 用户不存在时用于校验的固定哈希，首次使用时计算
 }
 
 // NewUserService 创建用户服务
-func NewUserService(db *pgxpool.Pool, redis *redis.Client, logger *zap.Logger, cfg *config.Config) *UserService {
+func NewUserService(db *pgxpool.Pool, redis *redis.Client, logger *zap.Logger, cfg *config.Config,
+	hasher utils.PasswordHasher, usernames *utils.UsernamePolicy, policy *utils.PasswordPolicy,
+	jwt *utils.JWTManager, sessions *session.Store, limiter *session.LoginLimiter, embedder Embedder) *UserService {
 	return &UserService{
-		db:     db,
-		redis:  redis,
-		logger: logger,
-		cfg:    cfg,
+		db:        db,
+		redis:     redis,
+		logger:    logger,
+		cfg:       cfg,
+		hasher:    hasher,
+		usernames: usernames,
+		policy:    policy,
+		jwt:       jwt,
+		sessions:  sessions,
+		limiter:   limiter,
+		embedder:  embedder,
 	}
 }
 
@@ -41,10 +72,18 @@ func (s *UserService) Register(ctx context.Context, req *pb.RegisterRequest) (*p
 	if req.Username == "" || req.Password == "" {
 		return nil, status.Error(codes.InvalidArgument, "username and password cannot be empty")
 	}
+	// 保存去掉首尾空白的用户名用于展示，唯一性和登录按规范形式判断
+	username := utils.CleanUsername(req.Username)
+	normalized := utils.NormalizeUsername(req.Username)
+	violations := fieldViolations("username", s.usernames.Validate(normalized))
+	violations = append(violations, fieldViolations("password", s.policy.Validate(username, req.Password))...)
+	if len(violations) > 0 {
+		return nil, badRequestError("invalid username or password", violations)
+	}
 
 	maxRetryTimes := 3
 	// 检查用户是否已存在
-	usernameKey := "register:" + req.Username
+	usernameKey := registerKey(normalized)
 	// 添加重试机制
 	for i := range maxRetryTimes {
 		_, err := s.redis.Get(ctx, usernameKey).Result()
@@ -58,23 +97,32 @@ func (s *UserService) Register(ctx context.Context, req *pb.RegisterRequest) (*p
 			time.Sleep(time.Duration(durationTime) * time.Millisecond)
 		}
 	}
+	passwordHash, err := s.hasher.Hash(req.Password)
+	if errors.Is(err, utils.ErrPasswordTooLong) {
+		return nil, status.Error(codes.InvalidArgument, "password is too long")
+	}
+	if err != nil {
+		s.logger.Error("hash password failed", zap.String("username", username), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to register user")
+	}
+	likes, likeEmbedding := s.embedLikes(ctx, req.Likes)
 	userId := utils.GenerateId()
-	go s.EnsureRedisSet(ctx, usernameKey, userId, 0)
-	go s.EnsureRedisSet(ctx, "login:"+req.Username, utils.EncryptPassword(req.Password), 0)
 	for i := range maxRetryTimes {
 		tx, err := s.db.Begin(ctx)
 		if err != nil {
 			s.logger.Error("begin transaction failed", zap.Error(err))
 			continue
 		}
-		_, err = s.db.Exec(ctx, "INSERT INTO users (id, username, password) VALUES ($1, $2, $3)",
-			userId, req.Username, req.Password)
+		// like_embedding由db.VectorCodec编码，nil写入NULL
+		_, err = s.db.Exec(ctx, `INSERT INTO users (id, username, username_normalized, password, likes, like_embedding)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+			userId, username, normalized, passwordHash, likes, likeEmbedding)
 		if err == nil {
-			s.logger.Info("user register success", zap.String("username", req.Username))
+			s.logger.Info("user register success", zap.String("username", username))
 			tx.Commit(ctx)
 			break
-		} else if err.Error() == "duplicate key value violates unique constraint \"users_username_key\"" {
-			s.logger.Error("user already exists", zap.String("username", req.Username))
+		} else if isUniqueViolation(err) {
+			s.logger.Error("user already exists", zap.String("username", username))
 			tx.Rollback(ctx)
 			return &pb.RegisterResponse{
 				Success: false,
@@ -88,33 +136,341 @@ func (s *UserService) Register(ctx context.Context, req *pb.RegisterRequest) (*p
 			time.Sleep(time.Duration(durationTime) * time.Millisecond)
 		}
 		if i == maxRetryTimes-1 {
-			s.logger.Error("user register failed", zap.String("username", req.Username), zap.Error(err))
+			s.logger.Error("user register failed", zap.String("username", username), zap.Error(err))
 			tx.Rollback(ctx)
 			return nil, status.Error(codes.Internal, "failed to register user")
 		}
 	}
-	s.logger.Info("user register completed", zap.String("username", req.Username), zap.String("userId", userId))
+	// Postgres是密码哈希的权威存储，写入成功后再更新Redis缓存，避免重复注册覆盖已有用户的缓存
+	go s.EnsureRedisSet(ctx, usernameKey, userId, 0)
+	go s.EnsureRedisSet(ctx, loginKey(normalized), passwordHash, 0)
+	s.logger.Info("user register completed", zap.String("username", username), zap.String("userId", userId))
 	return &pb.RegisterResponse{
 		Success: true,
 		UserId:  userId,
 	}, nil
 }
 
+// embedLikes 返回要保存的喜好文本和向量，没有喜好时都为nil
+// 计算向量失败不影响注册，向量保存为NULL，之后由回填任务补齐
+func (s *UserService) embedLikes(ctx context.Context, text string) (likes *string, embedding []float32) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	embedding, err := s.embedder.Embed(ctx, text)
+	if err != nil {
+		s.logger.Warn("embed likes failed", zap.Error(err))
+		return &text, nil
+	}
+	return &text, embedding
+}
+
 // Login 用户登录
 func (s *UserService) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
 	// 检查参数是否合理
 	if req.Username == "" || req.Password == "" {
 		return nil, status.Error(codes.InvalidArgument, "username and password cannot be empty")
 	}
-	// 检查用户是否存在
-	key := "login:" + req.Username
+	// 用户名按规范形式查找，Alice和alice是同一个用户
+	username := utils.NormalizeUsername(req.Username)
+	if username == "" {
+		return nil, status.Error(codes.InvalidArgument, "username and password cannot be empty")
+	}
+	// 检查用户名和客户端IP是否因多次失败被锁定
+	ip := clientIP(ctx)
+	if err := s.checkLoginLimit(ctx, username, ip); err != nil {
+		return nil, err
+	}
+	// 检查用户是否存在，不存在时与密码错误返回相同的错误，并照常校验一次密码，避免通过错误码或耗时枚举用户名
+	userID, result, err := s.loadCredentials(ctx, username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.verifyDummy(req.Password)
+		return nil, s.loginFailed(ctx, username, ip, errInvalidCredentials)
+	}
+	if err != nil {
+		s.logger.Error("user login failed", zap.String("username", username), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to login user")
+	}
+	// 检查密码是否正确
+	ok, rehash, err := s.hasher.Verify(req.Password, result)
+	if err != nil {
+		s.logger.Error("verify password failed", zap.String("username", username), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to login user")
+	}
+	if !ok {
+		return nil, s.loginFailed(ctx, username, ip, errInvalidCredentials)
+	}
+	if err := s.limiter.RecordSuccess(ctx, username); err != nil {
+		s.logger.Warn("reset login failures failed", zap.String("username", username), zap.Error(err))
+	}
+	// 旧算法或旧参数的哈希在登录成功后升级，失败不影响本次登录
+	if rehash {
+		if upgraded, err := s.hasher.Hash(req.Password); err != nil {
+			s.logger.Warn("rehash password failed", zap.String("username", username), zap.Error(err))
+		} else {
+			s.upgradePasswordHash(ctx, userID, username, upgraded)
+		}
+	}
+	// 角色和权限范围只保存在Postgres，修改后在下次登录或刷新时生效；令牌中使用注册时保存的用户名
+	displayName, roles, scopes, err := s.loadGrants(ctx, userID)
+	if err != nil {
+		s.logger.Error("load user roles failed", zap.String("username", username), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to login user")
+	}
+	token, err := s.jwt.GenerateToken(userID, displayName, roles, scopes)
+	if err != nil {
+		s.logger.Error("generate jwt failed", zap.String("username", username), zap.Error(err))
+		return &pb.LoginResponse{
+			Success: false,
+			Token:   "",
+		}, status.Error(codes.Internal, "failed to generate jwt")
+	}
+	refreshToken, err := s.sessions.IssueRefreshToken(ctx, userID)
+	if err != nil {
+		s.logger.Error("issue refresh token failed", zap.String("username", username), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to issue refresh token")
+	}
+	s.logger.Info("user login success", zap.String("username", username), zap.String("user_id", userID))
+	return &pb.LoginResponse{
+		Success:      true,
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.jwt.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// errInvalidCredentials 用户不存在或密码错误
+var errInvalidCredentials = status.Error(codes.PermissionDenied, "invalid username or password")
+
+// dummyPassword 计算固定哈希的密码，只用于消耗与真实校验相同的时间
+const dummyPassword = "tx-login-dummy-password"
+
+// verifyDummy 用户不存在时用固定哈希校验一次密码，使响应时间与密码错误时一致
+func (s *UserService) verifyDummy(password string) {
+	s.dummyOnce.Do(func() {
+		hash, err := s.hasher.Hash(dummyPassword)
+		if err != nil {
+			s.logger.Warn("hash dummy password failed", zap.Error(err))
+			return
+		}
+		s.dummyHash = hash
+	})
+	if s.dummyHash == "" {
+		return
+	}
+	// 结果不影响返回值，超长密码等错误同样忽略
+	_, _, _ = s.hasher.Verify(password, s.dummyHash)
+}
+
+// fieldViolations 将策略检查结果转换为字段错误
+func fieldViolations(field string, violations []utils.PolicyViolation) []*errdetails.BadRequest_FieldViolation {
+	result := make([]*errdetails.BadRequest_FieldViolation, 0, len(violations))
+	for _, v := range violations {
+		result = append(result, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Reason:      v.Reason,
+			Description: v.Description,
+		})
+	}
+	return result
+}
+
+// badRequestError 返回InvalidArgument，在状态详情中附带每个字段的错误
+func badRequestError(msg string, violations []*errdetails.BadRequest_FieldViolation) error {
+	st := status.New(codes.InvalidArgument, msg)
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// checkLoginLimit 已被锁定时返回ResourceExhausted，否则按之前的失败次数延迟
+// Redis不可用时只记录日志并放行，登录本身可以回退到Postgres
+func (s *UserService) checkLoginLimit(ctx context.Context, username, ip string) error {
+	attempt, err := s.limiter.Check(ctx, username, ip)
+	if err != nil {
+		s.logger.Warn("check login limit failed", zap.String("username", username), zap.String("ip", ip), zap.Error(err))
+		return nil
+	}
+	if attempt.Locked() {
+		return loginLockedError(attempt.RetryAfter)
+	}
+	if attempt.Delay > 0 {
+		timer := time.NewTimer(attempt.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	return nil
+}
+
+// loginFailed 记录一次失败登录，触发锁定时写审计日志并返回ResourceExhausted，否则返回原错误
+func (s *UserService) loginFailed(ctx context.Context, username, ip string, cause error) error {
+	attempt, err := s.limiter.RecordFailure(ctx, username, ip)
+	if err != nil {
+		s.logger.Warn("record login failure failed", zap.String("username", username), zap.String("ip", ip), zap.Error(err))
+		return cause
+	}
+	if !attempt.Locked() {
+		return cause
+	}
+	s.logger.Named("audit").Warn("login locked out",
+		zap.String("event", "login_lockout"),
+		zap.String("username", username),
+		zap.String("ip", ip),
+		zap.Strings("locked_by", attempt.LockedBy),
+		zap.Int("failures", attempt.Failures),
+		zap.Duration("lockout", attempt.RetryAfter))
+	return loginLockedError(attempt.RetryAfter)
+}
+
+// loginLockedError 锁定时的错误，在状态详情中附带RetryInfo告诉客户端何时重试
+func loginLockedError(retryAfter time.Duration) error {
+	st := status.New(codes.ResourceExhausted, "too many failed login attempts, try again later")
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter.Round(time.Second))})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// clientIP 从gRPC peer获取客户端IP，获取不到时返回空字符串
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// RefreshToken 使用刷新令牌换取新的访问令牌，旧的刷新令牌随之失效
+func (s *UserService) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, status.Error(codes.InvalidArgument, "refresh token cannot be empty")
+	}
+	userID, refreshToken, err := s.sessions.RotateRefreshToken(ctx, req.RefreshToken)
+	if errors.Is(err, session.ErrRefreshTokenReused) {
+		s.logger.Warn("refresh token reused, all sessions revoked", zap.String("user_id", userID))
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	if errors.Is(err, session.ErrInvalidRefreshToken) {
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	if err != nil {
+		s.logger.Error("rotate refresh token failed", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+	// 用户名、角色和权限范围以数据库为准，令牌签发后的修改在刷新时生效
+	username, roles, scopes, err := s.loadGrants(ctx, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, status.Error(codes.Unauthenticated, "user no longer exists")
+	}
+	if err != nil {
+		s.logger.Error("load user failed", zap.String("user_id", userID), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to refresh token")
+	}
+	token, err := s.jwt.GenerateToken(userID, username, roles, scopes)
+	if err != nil {
+		s.logger.Error("generate jwt failed", zap.String("user_id", userID), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to generate jwt")
+	}
+	return &pb.RefreshTokenResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(s.jwt.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// Logout 吊销当前访问令牌和给定的刷新令牌，all_sessions为true时吊销该用户的所有会话
+func (s *UserService) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	principal, ok := interceptor.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "not authenticated")
+	}
+	if req.RefreshToken != "" {
+		err := s.sessions.RevokeRefreshToken(ctx, principal.UserID, req.RefreshToken)
+		if errors.Is(err, session.ErrInvalidRefreshToken) {
+			return nil, status.Error(codes.InvalidArgument, "refresh token does not belong to the current user")
+		}
+		if err != nil {
+			s.logger.Error("revoke refresh token failed", zap.String("user_id", principal.UserID), zap.Error(err))
+			return nil, status.Error(codes.Internal, "failed to logout")
+		}
+	}
+	if err := s.sessions.RevokeAccessToken(ctx, principal.Claims); err != nil {
+		s.logger.Error("revoke access token failed", zap.String("user_id", principal.UserID), zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to logout")
+	}
+	if req.AllSessions {
+		if err := s.sessions.RevokeUser(ctx, principal.UserID); err != nil {
+			s.logger.Error("revoke user sessions failed", zap.String("user_id", principal.UserID), zap.Error(err))
+			return nil, status.Error(codes.Internal, "failed to logout")
+		}
+	}
+	s.logger.Info("user logout", zap.String("user_id", principal.UserID), zap.Bool("all_sessions", req.AllSessions))
+	return &pb.LogoutResponse{Success: true}, nil
+}
+
+// GetJWKS 返回校验token用的公钥
+func (s *UserService) GetJWKS(ctx context.Context, req *pb.GetJWKSRequest) (*pb.GetJWKSResponse, error) {
+	resp := &pb.GetJWKSResponse{}
+	for _, key := range s.jwt.JWKS() {
+		resp.Keys = append(resp.Keys, &pb.JWK{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Alg: key.Alg,
+			Use: key.Use,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+		})
+	}
+	return resp, nil
+}
+
+// registerKey 用户ID在Redis中的键，username为规范形式
+func registerKey(username string) string {
+	return "register:" + username
+}
+
+// loginKey 密码哈希在Redis中的键，username为规范形式
+func loginKey(username string) string {
+	return "login:" + username
+}
+
+// pgUniqueViolation 违反唯一约束的SQLSTATE
+const pgUniqueViolation = "23505"
+
+// isUniqueViolation 是否违反唯一约束
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// loadCredentials 获取用户ID和密码哈希，优先读取Redis缓存，缓存不完整或Redis不可用时读取Postgres
+// username为规范形式，用户不存在时返回pgx.ErrNoRows
+func (s *UserService) loadCredentials(ctx context.Context, username string) (userID, passwordHash string, err error) {
+	idKey, hashKey := registerKey(username), loginKey(username)
 	// 添加重试机制
 	maxRetryTimes := 3
-	var result string
-	var err error
 	for i := range maxRetryTimes {
-		result, err = s.redis.Get(ctx, key).Result()
+		values, err := s.redis.MGet(ctx, idKey, hashKey).Result()
 		if err == nil {
+			userID, idOK := values[0].(string)
+			passwordHash, hashOK := values[1].(string)
+			if idOK && hashOK {
+				return userID, passwordHash, nil
+			}
 			break
 		}
 		// 指数退避
@@ -124,27 +480,48 @@ func (s *UserService) Login(ctx context.Context, req *pb.LoginRequest) (*pb.Logi
 			time.Sleep(time.Duration(durationTime) * time.Millisecond)
 		}
 		if i == maxRetryTimes-1 {
-			s.logger.Error("user login failed", zap.String("username", req.Username), zap.Error(err))
-			return nil, status.Error(codes.Internal, "failed to login user")
+			s.logger.Warn("redis unavailable, falling back to postgres", zap.String("username", username), zap.Error(err))
 		}
 	}
-	// 检查密码是否正确
-	if result != utils.EncryptPassword(req.Password) {
-		return nil, status.Error(codes.PermissionDenied, "password is incorrect")
-	}
-	jwt, err := utils.GenerateToken(req.Username)
+
+	err = s.db.QueryRow(ctx, "SELECT id, password FROM users WHERE username_normalized = $1", username).Scan(&userID, &passwordHash)
 	if err != nil {
-		s.logger.Error("generate jwt failed", zap.String("username", req.Username), zap.Error(err))
-		return &pb.LoginResponse{
-			Success: false,
-			Token:   "",
-		}, status.Error(codes.Internal, "failed to generate jwt")
+		return "", "", err
 	}
-	s.logger.Info("user login success", zap.String("username", req.Username), zap.String("token", jwt))
-	return &pb.LoginResponse{
-		Success: true,
-		Token:   jwt,
-	}, nil
+	go s.EnsureRedisSet(ctx, idKey, userID, 0)
+	go s.EnsureRedisSet(ctx, hashKey, passwordHash, 0)
+	return userID, passwordHash, nil
+}
+
+// loadGrants 读取用户名以及写入访问令牌的角色和权限范围，用户不存在时返回pgx.ErrNoRows
+func (s *UserService) loadGrants(ctx context.Context, userID string) (username string, roles, scopes []string, err error) {
+	err = s.db.QueryRow(ctx, "SELECT username, roles, scopes FROM users WHERE id = $1", userID).Scan(&username, &roles, &scopes)
+	return username, roles, scopes, err
+}
+
+// authorizeUserAccess 检查调用方能否访问userID的数据：本人或拥有admin角色
+func authorizeUserAccess(ctx context.Context, userID string) error {
+	callerID, _ := ctx.Value(interceptor.UserIDKey).(string)
+	if callerID == "" {
+		return status.Error(codes.Unauthenticated, "not authenticated")
+	}
+	if callerID == userID {
+		return nil
+	}
+	if p, ok := interceptor.PrincipalFromContext(ctx); ok && p.HasRole(interceptor.RoleAdmin) {
+		return nil
+	}
+	return status.Error(codes.PermissionDenied, "cannot access another user's data")
+}
+
+// upgradePasswordHash 保存重新计算的密码哈希，先更新Postgres再刷新Redis缓存，失败不影响本次登录
+func (s *UserService) upgradePasswordHash(ctx context.Context, userID, username, passwordHash string) {
+	s.logger.Info("upgrading password hash", zap.String("username", username))
+	if _, err := s.db.Exec(ctx, "UPDATE users SET password = $1 WHERE id = $2", passwordHash, userID); err != nil {
+		s.logger.Warn("save upgraded password hash failed", zap.String("username", username), zap.Error(err))
+		return
+	}
+	s.EnsureRedisSet(ctx, loginKey(username), passwordHash, 0)
 }
 
 // GetUserInfo 获取用户信息
@@ -153,19 +530,35 @@ func (s *UserService) GetUserInfo(ctx context.Context, req *pb.GetUserInfoReques
 	if req.UserId == "" {
 		return nil, status.Error(codes.InvalidArgument, "userId cannot be empty")
 	}
+	// 只允许查询自己的信息，管理员除外
+	if err := authorizeUserAccess(ctx, req.UserId); err != nil {
+		s.logger.Warn("user get info denied", zap.String("userId", req.UserId), zap.Error(err))
+		return nil, err
+	}
 	maxRetryTimes := 3
 	var username string
+	var likes *string
 	var likeEmbedding []float32
+	var updatedAt time.Time
 	for i := range maxRetryTimes {
-		// 检查用户是否存在
-		err := s.db.QueryRow(ctx, "SELECT username, like_embedding FROM users WHERE id = $1", req.UserId).Scan(&username, &likeEmbedding)
+		// 检查用户是否存在，没有填写喜好的用户likes和like_embedding为NULL
+		err := s.db.QueryRow(ctx, "SELECT username, likes, like_embedding, updated_at FROM users WHERE id = $1", req.UserId).
+			Scan(&username, &likes, &likeEmbedding, &updatedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
 		if err == nil {
 			s.logger.Info("user get info success", zap.String("userId", req.UserId))
-			return &pb.GetUserInfoResponse{
+			resp := &pb.GetUserInfoResponse{
 				UserId:        req.UserId,
 				Username:      username,
 				LikeEmbedding: likeEmbedding,
-			}, nil
+				UpdatedAt:     timestamppb.New(updatedAt),
+			}
+			if likes != nil {
+				resp.Likes = *likes
+			}
+			return resp, nil
 		}
 		// 指数退避
 		durationTime := 1 << i
@@ -0,0 +1,460 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+	"tx/internal/interceptor"
+	"tx/internal/session"
+	"tx/pkg/db"
+	"tx/pkg/db/dbtest"
+	"tx/pkg/db/redistest"
+	"tx/pkg/utils"
+	pb "tx/proto/gen"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestAuthorizeUserAccess(t *testing.T) {
+	const owner, other = "1111111111", "2222222222"
+	principal := func(userID string, roles ...string) context.Context {
+		claims := &utils.Claims{UserId: userID, Roles: roles}
+		return interceptor.ContextWithPrincipal(context.Background(), &interceptor.Principal{UserID: userID, Roles: roles, Claims: claims})
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		userID string
+		code   codes.Code
+	}{
+		{"Owner", principal(owner), owner, codes.OK},
+		{"OwnerWithAdmin", principal(owner, interceptor.RoleAdmin), owner, codes.OK},
+		{"OtherUser", principal(other), owner, codes.PermissionDenied},
+		{"OtherUserWithUnrelatedRole", principal(other, "ops"), owner, codes.PermissionDenied},
+		{"Admin", principal(other, "ops", interceptor.RoleAdmin), owner, codes.OK},
+		{"UserIDKeyOnly", context.WithValue(context.Background(), interceptor.UserIDKey, owner), owner, codes.OK},
+		{"UserIDKeyOnlyOtherUser", context.WithValue(context.Background(), interceptor.UserIDKey, other), owner, codes.PermissionDenied},
+		{"Unauthenticated", context.Background(), owner, codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, status.Code(authorizeUserAccess(tt.ctx, tt.userID)))
+		})
+	}
+}
+
+func TestUserService_GetUserInfo_Denied(t *testing.T) {
+	// The ownership check runs before any database access, so no pool is needed.
+	svc := &UserService{logger: zap.NewNop()}
+	ctx := context.WithValue(context.Background(), interceptor.UserIDKey, "2222222222")
+	_, err := svc.GetUserInfo(ctx, &pb.GetUserInfoRequest{UserId: "1111111111"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestLoginLockedError(t *testing.T) {
+	st := status.Convert(loginLockedError(90*time.Second + 300*time.Millisecond))
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	assert.Equal(t, 90*time.Second, info.RetryDelay.AsDuration())
+}
+
+func TestClientIP(t *testing.T) {
+	withPeer := func(addr net.Addr) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	}
+	assert.Equal(t, "", clientIP(context.Background()))
+	assert.Equal(t, "10.0.0.7", clientIP(withPeer(&net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 51234})))
+	assert.Equal(t, "2001:db8::1", clientIP(withPeer(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443})))
+	assert.Equal(t, "/tmp/tx.sock", clientIP(withPeer(&net.UnixAddr{Name: "/tmp/tx.sock", Net: "unix"})))
+}
+
+func TestUserService_Register_Validation(t *testing.T) {
+	cfg := &config.Config{Auth: config.AuthConfig{
+		Username: config.UsernameConfig{MinLength: 3, MaxLength: 32, AllowedSymbols: "._-", Reserved: []string{"admin"}},
+		Password: config.PasswordConfig{Policy: config.PasswordPolicyConfig{MinLength: 8, RequireDigit: true}},
+	}}
+	usernames, err := utils.NewUsernamePolicy(cfg)
+	require.NoError(t, err)
+	policy, err := utils.NewPasswordPolicy(cfg)
+	require.NoError(t, err)
+	// Validation runs before Redis or Postgres are touched.
+	svc := &UserService{logger: zap.NewNop(), usernames: usernames, policy: policy}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     []string // field:reason
+	}{
+		{"Password", "alice", "alice", []string{
+			"password:" + utils.PasswordTooShort,
+			"password:" + utils.PasswordMissingDigit,
+			"password:" + utils.PasswordContainsUsername,
+		}},
+		{"ReservedUsername", " Admin ", "correct horse 1", []string{"username:" + utils.UsernameReserved}},
+		{"Both", "a:b", "short", []string{
+			"username:" + utils.UsernameInvalidCharacter,
+			"password:" + utils.PasswordTooShort,
+			"password:" + utils.PasswordMissingDigit,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := svc.Register(context.Background(), &pb.RegisterRequest{Username: tt.username, Password: tt.password})
+			st := status.Convert(err)
+			assert.Equal(t, codes.InvalidArgument, st.Code())
+			require.Len(t, st.Details(), 1)
+			badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+			require.True(t, ok)
+			var got []string
+			for _, v := range badRequest.FieldViolations {
+				got = append(got, v.Field+":"+v.Reason)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRedisKeys(t *testing.T) {
+	// Keys are built from the normalized name, so every spelling of a user shares one cache entry.
+	normalized := utils.NormalizeUsername(" ＡＬＩＣＥ ")
+	assert.Equal(t, "register:alice", registerKey(normalized))
+	assert.Equal(t, "login:alice", loginKey(normalized))
+}
+
+// failingEmbedder simulates an unavailable embedding service.
+type failingEmbedder struct{}
+
+func (failingEmbedder) Embed(context.Context, string) ([]float32, error) {
+	return nil, errors.New("embedding service unavailable")
+}
+
+func (failingEmbedder) Dimensions() int { return 384 }
+
+func TestUserService_EmbedLikes(t *testing.T) {
+	ctx := context.Background()
+	svc := &UserService{logger: zap.NewNop(), embedder: NewHashingEmbedder(384)}
+
+	likes, vec := svc.embedLikes(ctx, "  hiking and photography ")
+	require.NotNil(t, likes)
+	assert.Equal(t, "hiking and photography", *likes)
+	assert.Len(t, vec, 384)
+
+	likes, vec = svc.embedLikes(ctx, "   ")
+	assert.Nil(t, likes)
+	assert.Nil(t, vec)
+
+	// Registration still stores the likes when the embedder fails; the vector is backfilled later.
+	svc.embedder = failingEmbedder{}
+	likes, vec = svc.embedLikes(ctx, "hiking")
+	require.NotNil(t, likes)
+	assert.Equal(t, "hiking", *likes)
+	assert.Nil(t, vec)
+}
+
+func TestUserService_GetUserInfo(t *testing.T) {
+	srv := dbtest.NewServer(t)
+	srv.HandleVector()
+	// like_embedding as returned by PostgreSQL for '[0.6,0.8]'::vector.
+	embedding := dbtest.Raw{Text: "[0.6,0.8]", Binary: []byte{0x00, 0x02, 0x00, 0x00, 0x3f, 0x19, 0x99, 0x9a, 0x3f, 0x4c, 0xcc, 0xcd}}
+	updatedAt := time.Date(2024, 5, 1, 8, 30, 0, 123456000, time.UTC)
+	srv.Handle("SELECT username, likes, like_embedding, updated_at FROM users WHERE id = $1", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID},
+		Columns: []dbtest.Column{
+			{Name: "username", OID: pgtype.TextOID},
+			{Name: "likes", OID: pgtype.TextOID},
+			{Name: "like_embedding", OID: dbtest.VectorOID},
+			{Name: "updated_at", OID: pgtype.TimestamptzOID},
+		},
+		Rows: func(q dbtest.Query) [][]any {
+			switch string(q.Params[0]) {
+			case "1111111111":
+				return [][]any{{"Alice", "hiking, jazz", embedding, updatedAt}}
+			case "2222222222":
+				return [][]any{{"Bob", nil, nil, updatedAt}}
+			}
+			return nil
+		},
+	})
+	pool, err := db.NewPostgresClient(&config.Config{Postgres: srv.Config()})
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	svc := &UserService{db: pool, logger: zap.NewNop()}
+
+	tests := []struct {
+		name   string
+		userID string
+		want   *pb.GetUserInfoResponse
+		code   codes.Code
+	}{
+		{"WithLikes", "1111111111", &pb.GetUserInfoResponse{UserId: "1111111111", Username: "Alice", Likes: "hiking, jazz", LikeEmbedding: []float32{0.6, 0.8}, UpdatedAt: timestamppb.New(updatedAt)}, codes.OK},
+		{"WithoutLikes", "2222222222", &pb.GetUserInfoResponse{UserId: "2222222222", Username: "Bob", UpdatedAt: timestamppb.New(updatedAt)}, codes.OK},
+		{"NotFound", "3333333333", nil, codes.NotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), interceptor.UserIDKey, tt.userID)
+			resp, err := svc.GetUserInfo(ctx, &pb.GetUserInfoRequest{UserId: tt.userID})
+			assert.Equal(t, tt.code, status.Code(err))
+			if tt.want == nil {
+				assert.Nil(t, resp)
+				return
+			}
+			assert.True(t, proto.Equal(tt.want, resp), "got %v", resp)
+		})
+	}
+}
+
+// loginFixture wires a UserService to stand-in Postgres and Redis servers with cheap password hashing.
+type loginFixture struct {
+	svc   *UserService
+	db    *dbtest.Server
+	redis *redistest.Server
+	hash  string // hash of loginPassword stored for alice
+}
+
+const (
+	loginUserID   = "1111111111"
+	loginPassword = "correct horse 1"
+)
+
+func newLoginFixture(t *testing.T, limit config.LoginLimitConfig) *loginFixture {
+	t.Helper()
+	t.Setenv("TEST_JWT_SECRET", strings.Repeat("s", 32))
+	cfg := &config.Config{Auth: config.AuthConfig{
+		JWT: config.JWTConfig{
+			Issuer:          "tx",
+			AccessTokenTTL:  time.Hour,
+			RefreshTokenTTL: 24 * time.Hour,
+			SigningKey:      "hs",
+			Keys:            []config.JWTKeyConfig{{KID: "hs", Algorithm: utils.JWTAlgorithmHS256, SecretEnv: "TEST_JWT_SECRET"}},
+		},
+		Password: config.PasswordConfig{
+			Algorithm:  utils.PasswordAlgorithmArgon2id,
+			Argon2id:   config.Argon2idConfig{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16},
+			BcryptCost: 4,
+		},
+		LoginLimit: limit,
+	}}
+	hasher, err := utils.NewPasswordHasher(cfg)
+	require.NoError(t, err)
+	hash, err := hasher.Hash(loginPassword)
+	require.NoError(t, err)
+	jwtManager, err := utils.NewJWTManager(cfg)
+	require.NoError(t, err)
+
+	f := &loginFixture{db: dbtest.NewServer(t), redis: redistest.NewServer(t), hash: hash}
+	f.db.HandleVector()
+	f.db.Handle("SELECT id, password FROM users WHERE username_normalized = $1", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID},
+		Columns:   []dbtest.Column{{Name: "id", OID: pgtype.TextOID}, {Name: "password", OID: pgtype.TextOID}},
+		Rows: func(q dbtest.Query) [][]any {
+			if string(q.Params[0]) == "alice" {
+				return [][]any{{loginUserID, hash}}
+			}
+			return nil
+		},
+	})
+	f.db.Handle("SELECT username, roles, scopes FROM users WHERE id = $1", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID},
+		Columns: []dbtest.Column{
+			{Name: "username", OID: pgtype.TextOID},
+			{Name: "roles", OID: pgtype.TextArrayOID},
+			{Name: "scopes", OID: pgtype.TextArrayOID},
+		},
+		Rows: func(q dbtest.Query) [][]any {
+			if string(q.Params[0]) == loginUserID {
+				return [][]any{{"Alice", []string{"ops"}, []string{}}}
+			}
+			return nil
+		},
+	})
+	pool, err := db.NewPostgresClient(&config.Config{Postgres: f.db.Config()})
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	redisClient, err := db.NewRedisClient(&config.Config{Redis: f.redis.Config()})
+	require.NoError(t, err)
+	t.Cleanup(func() { redisClient.Close() })
+
+	f.svc = NewUserService(pool, redisClient, zap.NewNop(), cfg, hasher, nil, nil, jwtManager,
+		session.NewStore(redisClient, cfg), session.NewLoginLimiter(redisClient, cfg), nil)
+	return f
+}
+
+func TestUserService_Login_PostgresFallback(t *testing.T) {
+	f := newLoginFixture(t, config.LoginLimitConfig{})
+	// Only the user ID is cached; the missing login: key forces a read from Postgres.
+	f.redis.Set("register:alice", loginUserID)
+
+	resp, err := f.svc.Login(context.Background(), &pb.LoginRequest{Username: " ALICE ", Password: loginPassword})
+	require.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.NotEmpty(t, resp.RefreshToken)
+	claims, err := f.svc.jwt.ParseToken(resp.Token)
+	require.NoError(t, err)
+	assert.Equal(t, loginUserID, claims.UserId)
+	assert.Equal(t, "Alice", claims.Username)
+	assert.Equal(t, []string{"ops"}, claims.Roles)
+
+	var lookups int
+	for _, q := range f.db.Queries() {
+		if strings.Contains(q.SQL, "username_normalized") {
+			lookups++
+			assert.Equal(t, "alice", string(q.Params[0]))
+		}
+	}
+	assert.Equal(t, 1, lookups)
+	// The credentials read from Postgres are written back to the cache.
+	assert.Eventually(t, func() bool {
+		hash, ok := f.redis.Get("login:alice")
+		return ok && hash == f.hash
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestUserService_Login_InvalidCredentials(t *testing.T) {
+	f := newLoginFixture(t, config.LoginLimitConfig{})
+
+	_, wrongPassword := f.svc.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: "wrong horse 1"})
+	_, unknownUser := f.svc.Login(context.Background(), &pb.LoginRequest{Username: "mallory", Password: loginPassword})
+	// Callers cannot tell an unknown username from a wrong password.
+	assert.Equal(t, codes.PermissionDenied, status.Code(wrongPassword))
+	assert.Equal(t, status.Convert(wrongPassword).Proto(), status.Convert(unknownUser).Proto())
+	assert.NotEmpty(t, f.svc.dummyHash, "a missing user must still verify a password")
+}
+
+func TestUserService_Login_Lockout(t *testing.T) {
+	f := newLoginFixture(t, config.LoginLimitConfig{Window: time.Minute, MaxUserFailures: 2, MaxIPFailures: 10, LockoutDuration: 10 * time.Minute})
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 51234}})
+
+	_, err := f.svc.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "wrong horse 1"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	assertLocked := func(t *testing.T, err error) {
+		t.Helper()
+		st := status.Convert(err)
+		require.Equal(t, codes.ResourceExhausted, st.Code())
+		require.Len(t, st.Details(), 1)
+		info, ok := st.Details()[0].(*errdetails.RetryInfo)
+		require.True(t, ok)
+		assert.InDelta(t, 10*time.Minute, info.RetryDelay.AsDuration(), float64(time.Second))
+	}
+	// The failure that reaches the limit already reports the lockout.
+	_, err = f.svc.Login(ctx, &pb.LoginRequest{Username: "alice", Password: "wrong horse 1"})
+	assertLocked(t, err)
+	// While locked even the right password is rejected before it is checked.
+	_, err = f.svc.Login(ctx, &pb.LoginRequest{Username: "alice", Password: loginPassword})
+	assertLocked(t, err)
+	assert.True(t, f.redis.Exists("login_lock:user:alice"))
+
+	f.redis.FastForward(10 * time.Minute)
+	resp, err := f.svc.Login(ctx, &pb.LoginRequest{Username: "alice", Password: loginPassword})
+	require.NoError(t, err)
+	assert.True(t, resp.Success)
+}
+
+// callAuthenticated runs a UserService method behind the auth interceptor the way the gRPC server does.
+func (f *loginFixture) callAuthenticated(t *testing.T, token, method string, handler grpc.UnaryHandler) (any, error) {
+	t.Helper()
+	policies, err := interceptor.NewPolicyTable(&config.Config{})
+	require.NoError(t, err)
+	unary := interceptor.NewAuthInterceptor(zap.NewNop(), f.svc.jwt, f.svc.sessions, policies).Unary()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+	return unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+}
+
+func (f *loginFixture) login(t *testing.T) *pb.LoginResponse {
+	t.Helper()
+	resp, err := f.svc.Login(context.Background(), &pb.LoginRequest{Username: "alice", Password: loginPassword})
+	require.NoError(t, err)
+	return resp
+}
+
+func TestUserService_RefreshToken(t *testing.T) {
+	f := newLoginFixture(t, config.LoginLimitConfig{})
+	ctx := context.Background()
+	login := f.login(t)
+
+	refreshed, err := f.svc.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	require.NoError(t, err)
+	assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+	assert.Equal(t, int64(time.Hour.Seconds()), refreshed.ExpiresIn)
+	claims, err := f.svc.jwt.ParseToken(refreshed.Token)
+	require.NoError(t, err)
+	assert.Equal(t, loginUserID, claims.UserId)
+	assert.Equal(t, []string{"ops"}, claims.Roles, "Roles are reloaded from Postgres")
+	_, err = f.callAuthenticated(t, refreshed.Token, "/user.UserService/GetUserInfo", func(context.Context, any) (any, error) { return nil, nil })
+	require.NoError(t, err)
+
+	// Replaying the rotated token signs the user out everywhere.
+	_, err = f.svc.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = f.svc.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: refreshed.RefreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = f.callAuthenticated(t, refreshed.Token, "/user.UserService/GetUserInfo", func(context.Context, any) (any, error) { return nil, nil })
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestUserService_Logout(t *testing.T) {
+	logout := func(f *loginFixture, t *testing.T, token string, req *pb.LogoutRequest) error {
+		_, err := f.callAuthenticated(t, token, "/user.UserService/Logout", func(ctx context.Context, _ any) (any, error) {
+			return f.svc.Logout(ctx, req)
+		})
+		return err
+	}
+
+	t.Run("CurrentSession", func(t *testing.T) {
+		f := newLoginFixture(t, config.LoginLimitConfig{})
+		current, other := f.login(t), f.login(t)
+
+		require.NoError(t, logout(f, t, current.Token, &pb.LogoutRequest{RefreshToken: current.RefreshToken}))
+		assert.Equal(t, codes.Unauthenticated, status.Code(logout(f, t, current.Token, &pb.LogoutRequest{})),
+			"the access token is revoked")
+		_, err := f.svc.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: current.RefreshToken})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), "the refresh token is revoked")
+
+		// Other sessions stay valid.
+		_, err = f.svc.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: other.RefreshToken})
+		assert.NoError(t, err)
+	})
+
+	t.Run("AllSessions", func(t *testing.T) {
+		f := newLoginFixture(t, config.LoginLimitConfig{})
+		current, other := f.login(t), f.login(t)
+
+		require.NoError(t, logout(f, t, current.Token, &pb.LogoutRequest{AllSessions: true}))
+		assert.Equal(t, codes.Unauthenticated, status.Code(logout(f, t, other.Token, &pb.LogoutRequest{})))
+		_, err := f.svc.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: other.RefreshToken})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("ForeignRefreshToken", func(t *testing.T) {
+		f := newLoginFixture(t, config.LoginLimitConfig{})
+		current := f.login(t)
+		foreign, err := f.svc.sessions.IssueRefreshToken(context.Background(), "2222222222")
+		require.NoError(t, err)
+
+		err = logout(f, t, current.Token, &pb.LogoutRequest{RefreshToken: foreign})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, _, err = f.svc.sessions.RotateRefreshToken(context.Background(), foreign)
+		assert.NoError(t, err, "another user's refresh token must survive")
+	})
+}
@@ -33,3 +33,21 @@ func (s *UserService) EnsureRedisSet(ctx context.Context, key string, value stri
 		log.Printf("failed to set Redis key %s after %d attempts", key, maxRetryTimes)
 	}
 }
+
+// EnsureRedisDel 删除Redis键，失败时重试，返回最后一次的错误
+func (s *UserService) EnsureRedisDel(ctx context.Context, keys ...string) error {
+	var err error
+	maxRetryTimes := 5
+	for i := 0; i < maxRetryTimes; i++ {
+		if err = s.redis.Del(ctx, keys...).Err(); err == nil {
+			return nil
+		}
+		if i == maxRetryTimes-1 {
+			break
+		}
+		durationTime := 1 << i
+		log.Printf("failed to delete Redis keys %v, retrying in %d milliseconds (%d/%d)", keys, durationTime, i+1, maxRetryTimes)
+		time.Sleep(time.Duration(durationTime) * time.Millisecond)
+	}
+	return err
+}
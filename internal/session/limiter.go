@@ -0,0 +1,172 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"tx/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	loginFailuresPrefix = "login_failures:" // login_failures:<user|ip>:<值> -> 窗口内失败时间的有序集合
+	loginLockPrefix     = "login_lock:"     // login_lock:<user|ip>:<值> -> 锁定标记，过期后自动解锁
+)
+
+// 锁定维度
+const (
+	LockByUsername = "username"
+	LockByIP       = "ip"
+)
+
+// LoginAttempt 一次登录尝试的限制状态
+type LoginAttempt struct {
+	RetryAfter time.Duration // 大于0表示用户名或IP已被锁定，需要等待的时间，两者都被锁定时取较长的一个
+	LockedBy   []string      // 被锁定的维度，LockByUsername和LockByIP中的一个或两个
+	Delay      time.Duration // 校验密码前需要等待的时间，随失败次数递增
+	Failures   int           // 窗口内该用户名的失败次数
+}
+
+// Locked 是否已被锁定
+func (a LoginAttempt) Locked() bool {
+	return a.RetryAfter > 0
+}
+
+// LoginLimiter 基于Redis滑动窗口统计登录失败次数，按用户名和客户端IP分别限制
+// 失败次数增加时逐步延迟响应，在窗口内达到上限后锁定一段时间
+type LoginLimiter struct {
+	redis *redis.Client
+	cfg   config.LoginLimitConfig
+	now   func() time.Time // 滑动窗口使用的时钟，测试时替换
+}
+
+// NewLoginLimiter 创建登录限制器
+func NewLoginLimiter(redis *redis.Client, cfg *config.Config) *LoginLimiter {
+	return &LoginLimiter{redis: redis, cfg: cfg.Auth.LoginLimit, now: time.Now}
+}
+
+// Check 在校验密码前检查是否已被锁定，并返回需要的延迟
+// ip为空时只检查用户名
+func (l *LoginLimiter) Check(ctx context.Context, username, ip string) (LoginAttempt, error) {
+	since := strconv.FormatInt(l.now().Add(-l.cfg.Window).UnixMilli(), 10)
+	pipe := l.redis.Pipeline()
+	userLock := pipe.PTTL(ctx, loginLockPrefix+"user:"+username)
+	userFailures := pipe.ZCount(ctx, loginFailuresPrefix+"user:"+username, "("+since, "+inf")
+	var ipLock *redis.DurationCmd
+	if ip != "" {
+		ipLock = pipe.PTTL(ctx, loginLockPrefix+"ip:"+ip)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return LoginAttempt{}, err
+	}
+
+	attempt := LoginAttempt{Failures: int(userFailures.Val())}
+	if d := userLock.Val(); d > 0 {
+		attempt.RetryAfter = d
+		attempt.LockedBy = append(attempt.LockedBy, LockByUsername)
+	}
+	if ipLock != nil && ipLock.Val() > 0 {
+		attempt.RetryAfter = max(attempt.RetryAfter, ipLock.Val())
+		attempt.LockedBy = append(attempt.LockedBy, LockByIP)
+	}
+	attempt.Delay = progressiveDelay(attempt.Failures, l.cfg)
+	return attempt, nil
+}
+
+// RecordFailure 记录一次失败，失败次数达到上限时锁定用户名或IP，可能同时锁定两者
+// 返回值Locked()为true表示这次失败触发了锁定
+func (l *LoginLimiter) RecordFailure(ctx context.Context, username, ip string) (LoginAttempt, error) {
+	now := l.now()
+	userFailures, err := l.addFailure(ctx, "user:"+username, now)
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+	ipFailures := 0
+	if ip != "" {
+		if ipFailures, err = l.addFailure(ctx, "ip:"+ip, now); err != nil {
+			return LoginAttempt{}, err
+		}
+	}
+
+	attempt := LoginAttempt{Failures: userFailures, Delay: progressiveDelay(userFailures, l.cfg)}
+	var locks []string
+	if exceeded(userFailures, l.cfg.MaxUserFailures) {
+		attempt.LockedBy = append(attempt.LockedBy, LockByUsername)
+		locks = append(locks, "user:"+username)
+	}
+	if ip != "" && exceeded(ipFailures, l.cfg.MaxIPFailures) {
+		attempt.LockedBy = append(attempt.LockedBy, LockByIP)
+		locks = append(locks, "ip:"+ip)
+	}
+	if len(locks) == 0 {
+		return attempt, nil
+	}
+
+	// 锁定后清空计数，解锁后重新开始统计
+	pipe := l.redis.TxPipeline()
+	for _, subject := range locks {
+		pipe.Set(ctx, loginLockPrefix+subject, 1, l.cfg.LockoutDuration)
+		pipe.Del(ctx, loginFailuresPrefix+subject)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return LoginAttempt{}, err
+	}
+	attempt.RetryAfter = l.cfg.LockoutDuration
+	return attempt, nil
+}
+
+// RecordSuccess 登录成功后清除该用户名的失败记录，IP的记录保留，避免攻击者用自己的账号重置计数
+func (l *LoginLimiter) RecordSuccess(ctx context.Context, username string) error {
+	return l.redis.Del(ctx, loginFailuresPrefix+"user:"+username).Err()
+}
+
+// addFailure 将本次失败加入滑动窗口并返回窗口内的失败次数
+func (l *LoginLimiter) addFailure(ctx context.Context, subject string, now time.Time) (int, error) {
+	key := loginFailuresPrefix + subject
+	member, err := failureMember(now)
+	if err != nil {
+		return 0, err
+	}
+	pipe := l.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: member})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-l.cfg.Window).UnixMilli(), 10))
+	count := pipe.ZCard(ctx, key)
+	pipe.PExpire(ctx, key, l.cfg.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// failureMember 有序集合的成员，同一毫秒内的多次失败也要分别计数
+func failureMember(now time.Time) (string, error) {
+	nonce := make([]byte, 4)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%s", now.UnixNano(), hex.EncodeToString(nonce)), nil
+}
+
+// exceeded 失败次数是否达到上限，上限为0表示不限制
+func exceeded(failures, limit int) bool {
+	return limit > 0 && failures >= limit
+}
+
+// progressiveDelay 前FreeAttempts次失败不延迟，之后从BaseDelay开始每次失败延迟翻倍，最长MaxDelay
+// MaxDelay不大于BaseDelay时固定延迟BaseDelay
+func progressiveDelay(failures int, cfg config.LoginLimitConfig) time.Duration {
+	n := failures - cfg.FreeAttempts
+	if n <= 0 || cfg.BaseDelay <= 0 {
+		return 0
+	}
+	delay := cfg.BaseDelay
+	for i := 1; i < n && delay < cfg.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, max(cfg.MaxDelay, cfg.BaseDelay))
+}
@@ -0,0 +1,179 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+	"tx/pkg/db"
+	"tx/pkg/db/redistest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressiveDelay(t *testing.T) {
+	cfg := config.LoginLimitConfig{FreeAttempts: 2, BaseDelay: 500 * time.Millisecond, MaxDelay: 4 * time.Second}
+
+	tests := []struct {
+		name     string
+		failures int
+		cfg      config.LoginLimitConfig
+		want     time.Duration
+	}{
+		{"NoFailures", 0, cfg, 0},
+		{"WithinFreeAttempts", 2, cfg, 0},
+		{"FirstDelayed", 3, cfg, 500 * time.Millisecond},
+		{"Doubles", 4, cfg, time.Second},
+		{"DoublesAgain", 6, cfg, 4 * time.Second},
+		{"CappedAtMax", 100, cfg, 4 * time.Second},
+		{"MaxBelowBase", 5, config.LoginLimitConfig{BaseDelay: time.Second, MaxDelay: time.Millisecond}, time.Second},
+		{"Disabled", 10, config.LoginLimitConfig{FreeAttempts: 2}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, progressiveDelay(tt.failures, tt.cfg))
+		})
+	}
+}
+
+func TestExceeded(t *testing.T) {
+	assert.False(t, exceeded(4, 5))
+	assert.True(t, exceeded(5, 5))
+	assert.False(t, exceeded(1000, 0), "A zero limit disables lockout")
+}
+
+func TestLoginAttemptLocked(t *testing.T) {
+	assert.False(t, LoginAttempt{Delay: time.Second}.Locked())
+	assert.True(t, LoginAttempt{RetryAfter: time.Minute, LockedBy: []string{LockByIP}}.Locked())
+}
+
+// testLimiter returns a limiter backed by a stand-in Redis and an advance function that moves
+// the limiter's clock and the server's expiry clock together.
+func testLimiter(t *testing.T, cfg config.LoginLimitConfig) (*LoginLimiter, *redistest.Server, func(time.Duration)) {
+	t.Helper()
+	srv := redistest.NewServer(t)
+	client, err := db.NewRedisClient(&config.Config{Redis: srv.Config()})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+
+	l := NewLoginLimiter(client, &config.Config{Auth: config.AuthConfig{LoginLimit: cfg}})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		srv.FastForward(d)
+	}
+	return l, srv, advance
+}
+
+func TestLoginLimiter_SlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	l, _, advance := testLimiter(t, config.LoginLimitConfig{
+		Window: time.Minute, MaxUserFailures: 5, FreeAttempts: 1, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second,
+	})
+
+	for range 2 {
+		attempt, err := l.RecordFailure(ctx, "alice", "10.0.0.1")
+		require.NoError(t, err)
+		assert.False(t, attempt.Locked())
+	}
+	advance(40 * time.Second)
+	_, err := l.RecordFailure(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+
+	attempt, err := l.Check(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, attempt.Failures)
+	assert.Equal(t, 200*time.Millisecond, attempt.Delay)
+
+	// The first two failures fall out of the window, the one 40s later is still counted.
+	advance(30 * time.Second)
+	attempt, err = l.Check(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, attempt.Failures)
+	assert.Zero(t, attempt.Delay)
+
+	attempt, err = l.RecordFailure(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempt.Failures)
+}
+
+func TestLoginLimiter_Lockout(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.LoginLimitConfig{Window: time.Minute, MaxUserFailures: 3, MaxIPFailures: 10, LockoutDuration: 5 * time.Minute}
+
+	t.Run("Username", func(t *testing.T) {
+		l, srv, advance := testLimiter(t, cfg)
+		for i := 1; i <= 3; i++ {
+			attempt, err := l.RecordFailure(ctx, "alice", "10.0.0.1")
+			require.NoError(t, err)
+			assert.Equal(t, i == 3, attempt.Locked(), "failure %d", i)
+		}
+		attempt, err := l.Check(ctx, "alice", "10.0.0.2")
+		require.NoError(t, err)
+		assert.True(t, attempt.Locked())
+		assert.Equal(t, []string{LockByUsername}, attempt.LockedBy)
+		assert.InDelta(t, 5*time.Minute, attempt.RetryAfter, float64(time.Second))
+		// Counts restart once the lock is in place.
+		assert.False(t, srv.Exists("login_failures:user:alice"))
+
+		advance(5 * time.Minute)
+		attempt, err = l.Check(ctx, "alice", "10.0.0.2")
+		require.NoError(t, err)
+		assert.False(t, attempt.Locked())
+		assert.Zero(t, attempt.Failures)
+	})
+
+	t.Run("IP", func(t *testing.T) {
+		l, _, _ := testLimiter(t, config.LoginLimitConfig{Window: time.Minute, MaxUserFailures: 10, MaxIPFailures: 2, LockoutDuration: 5 * time.Minute})
+		_, err := l.RecordFailure(ctx, "alice", "10.0.0.1")
+		require.NoError(t, err)
+		attempt, err := l.RecordFailure(ctx, "bob", "10.0.0.1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{LockByIP}, attempt.LockedBy)
+
+		attempt, err = l.Check(ctx, "carol", "10.0.0.1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{LockByIP}, attempt.LockedBy)
+		attempt, err = l.Check(ctx, "carol", "10.0.0.2")
+		require.NoError(t, err)
+		assert.False(t, attempt.Locked())
+	})
+
+	t.Run("Both", func(t *testing.T) {
+		l, _, _ := testLimiter(t, config.LoginLimitConfig{Window: time.Minute, MaxUserFailures: 2, MaxIPFailures: 2, LockoutDuration: 5 * time.Minute})
+		_, err := l.RecordFailure(ctx, "alice", "10.0.0.1")
+		require.NoError(t, err)
+		attempt, err := l.RecordFailure(ctx, "alice", "10.0.0.1")
+		require.NoError(t, err)
+		// Both dimensions tripped on the same failure and both are reported.
+		assert.Equal(t, []string{LockByUsername, LockByIP}, attempt.LockedBy)
+		assert.Equal(t, 5*time.Minute, attempt.RetryAfter)
+
+		attempt, err = l.Check(ctx, "alice", "10.0.0.1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{LockByUsername, LockByIP}, attempt.LockedBy)
+	})
+}
+
+func TestLoginLimiter_RecordSuccess(t *testing.T) {
+	ctx := context.Background()
+	l, srv, _ := testLimiter(t, config.LoginLimitConfig{Window: time.Minute, MaxUserFailures: 3, MaxIPFailures: 3, LockoutDuration: time.Minute})
+
+	for range 2 {
+		_, err := l.RecordFailure(ctx, "alice", "10.0.0.1")
+		require.NoError(t, err)
+	}
+	require.NoError(t, l.RecordSuccess(ctx, "alice"))
+
+	attempt, err := l.Check(ctx, "alice", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, attempt.Failures)
+	// The IP count survives so an attacker cannot reset it by logging into their own account.
+	assert.True(t, srv.Exists("login_failures:ip:10.0.0.1"))
+	attempt, err = l.RecordFailure(ctx, "bob", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{LockByIP}, attempt.LockedBy)
+}
@@ -0,0 +1,191 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"tx/internal/config"
+	"tx/pkg/utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	refreshKeyPrefix    = "refresh:"        // refresh:<令牌哈希> -> 用户ID
+	refreshUsedPrefix   = "refresh_used:"   // 已轮换的刷新令牌，再次出现说明令牌可能被盗用
+	userRefreshPrefix   = "refresh_tokens:" // refresh_tokens:<用户ID> -> 该用户所有刷新令牌哈希的集合
+	revokedJTIPrefix    = "revoked:jti:"    // 被吊销的访问令牌，保留到令牌过期
+	revokedBeforePrefix = "revoked_before:" // 该时间（unix秒）及之前签发给用户的访问令牌全部失效
+
+	refreshTokenBytes = 32 // 刷新令牌的随机字节数
+)
+
+var (
+	// ErrInvalidRefreshToken 刷新令牌不存在、已过期或不属于当前用户
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused 已经轮换过的刷新令牌被再次使用，用户的所有会话已被吊销
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// Store 在Redis中保存刷新令牌和访问令牌的吊销记录
+// 刷新令牌只保存SHA-256哈希，Redis数据泄露时无法直接使用
+type Store struct {
+	redis      *redis.Client
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+}
+
+// NewStore 创建会话存储
+func NewStore(redis *redis.Client, cfg *config.Config) *Store {
+	return &Store{
+		redis:      redis,
+		accessTTL:  cfg.Auth.JWT.AccessTokenTTL,
+		refreshTTL: cfg.Auth.JWT.RefreshTokenTTL,
+	}
+}
+
+// RefreshTokenTTL 刷新令牌的有效期
+func (s *Store) RefreshTokenTTL() time.Duration {
+	return s.refreshTTL
+}
+
+// IssueRefreshToken 为用户签发新的刷新令牌
+func (s *Store) IssueRefreshToken(ctx context.Context, userID string) (string, error) {
+	raw := make([]byte, refreshTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	hash := hashToken(token)
+
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, refreshKeyPrefix+hash, userID, s.refreshTTL)
+	pipe.SAdd(ctx, userRefreshPrefix+userID, hash)
+	pipe.Expire(ctx, userRefreshPrefix+userID, s.refreshTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RotateRefreshToken 使刷新令牌失效并签发新的刷新令牌，返回令牌所属的用户
+// 每个刷新令牌只能使用一次，已经轮换过的令牌再次出现时吊销该用户的所有会话
+func (s *Store) RotateRefreshToken(ctx context.Context, token string) (userID, next string, err error) {
+	hash := hashToken(token)
+	userID, err = s.redis.GetDel(ctx, refreshKeyPrefix+hash).Result()
+	if err == redis.Nil {
+		owner, err := s.redis.Get(ctx, refreshUsedPrefix+hash).Result()
+		if err == redis.Nil {
+			return "", "", ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return "", "", err
+		}
+		if err := s.RevokeUser(ctx, owner); err != nil {
+			return "", "", err
+		}
+		return owner, "", ErrRefreshTokenReused
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.Set(ctx, refreshUsedPrefix+hash, userID, s.refreshTTL)
+	pipe.SRem(ctx, userRefreshPrefix+userID, hash)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", "", err
+	}
+	next, err = s.IssueRefreshToken(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	return userID, next, nil
+}
+
+// RevokeRefreshToken 删除属于userID的刷新令牌，令牌已失效时直接返回
+func (s *Store) RevokeRefreshToken(ctx context.Context, userID, token string) error {
+	hash := hashToken(token)
+	owner, err := s.redis.Get(ctx, refreshKeyPrefix+hash).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if owner != userID {
+		return ErrInvalidRefreshToken
+	}
+	pipe := s.redis.TxPipeline()
+	pipe.Del(ctx, refreshKeyPrefix+hash)
+	pipe.SRem(ctx, userRefreshPrefix+userID, hash)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RevokeAccessToken 吊销一个访问令牌，记录保留到令牌过期
+func (s *Store) RevokeAccessToken(ctx context.Context, claims *utils.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.redis.Set(ctx, revokedJTIPrefix+claims.ID, 1, ttl).Err()
+}
+
+// RevokeUser 吊销用户此前签发的所有访问令牌和刷新令牌，用于退出所有设备或修改密码
+func (s *Store) RevokeUser(ctx context.Context, userID string) error {
+	hashes, err := s.redis.SMembers(ctx, userRefreshPrefix+userID).Result()
+	if err != nil {
+		return err
+	}
+	pipe := s.redis.TxPipeline()
+	// 之前签发的访问令牌最晚在accessTTL后过期，记录不需要保留更久
+	pipe.Set(ctx, revokedBeforePrefix+userID, time.Now().Unix(), s.accessTTL)
+	for _, hash := range hashes {
+		pipe.Del(ctx, refreshKeyPrefix+hash)
+	}
+	pipe.Del(ctx, userRefreshPrefix+userID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// IsRevoked 判断访问令牌是否已被吊销
+func (s *Store) IsRevoked(ctx context.Context, claims *utils.Claims) (bool, error) {
+	pipe := s.redis.Pipeline()
+	jti := pipe.Exists(ctx, revokedJTIPrefix+claims.ID)
+	before := pipe.Get(ctx, revokedBeforePrefix+claims.UserId)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return false, err
+	}
+	revokedBefore, err := before.Int64()
+	if err != nil && err != redis.Nil {
+		return false, err
+	}
+	return isRevoked(claims, jti.Val() > 0, revokedBefore), nil
+}
+
+// isRevoked 令牌被单独吊销，或签发时间不晚于用户的吊销时间时视为已吊销
+// iat精度为秒，与吊销同一秒签发的令牌也会失效
+func isRevoked(claims *utils.Claims, jtiRevoked bool, revokedBefore int64) bool {
+	if jtiRevoked {
+		return true
+	}
+	if revokedBefore == 0 {
+		return false
+	}
+	return claims.IssuedAt == nil || claims.IssuedAt.Unix() <= revokedBefore
+}
+
+// hashToken 刷新令牌在Redis中的键
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+	"tx/pkg/db"
+	"tx/pkg/db/redistest"
+	"tx/pkg/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRevoked(t *testing.T) {
+	issued := time.Unix(1_700_000_000, 0)
+	claims := &utils.Claims{UserId: "user-1", RegisteredClaims: jwt.RegisteredClaims{
+		ID:       "jti-1",
+		IssuedAt: jwt.NewNumericDate(issued),
+	}}
+
+	tests := []struct {
+		name          string
+		claims        *utils.Claims
+		jtiRevoked    bool
+		revokedBefore int64
+		want          bool
+	}{
+		{"NotRevoked", claims, false, 0, false},
+		{"TokenRevoked", claims, true, 0, true},
+		{"IssuedBeforeUserRevocation", claims, false, issued.Unix() + 1, true},
+		{"IssuedInSameSecondAsRevocation", claims, false, issued.Unix(), true},
+		{"IssuedAfterUserRevocation", claims, false, issued.Unix() - 1, false},
+		{"MissingIssuedAt", &utils.Claims{UserId: "user-1"}, false, issued.Unix(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isRevoked(tt.claims, tt.jtiRevoked, tt.revokedBefore))
+		})
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	assert.Equal(t, hashToken("token"), hashToken("token"))
+	assert.NotEqual(t, hashToken("token"), hashToken("other"))
+	assert.NotContains(t, hashToken("token"), "token", "Refresh tokens must not be stored in clear text")
+}
+
+func testStore(t *testing.T) (*Store, *redistest.Server) {
+	t.Helper()
+	srv := redistest.NewServer(t)
+	client, err := db.NewRedisClient(&config.Config{Redis: srv.Config()})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return NewStore(client, &config.Config{Auth: config.AuthConfig{JWT: config.JWTConfig{
+		AccessTokenTTL:  15 * time.Minute,
+		RefreshTokenTTL: 24 * time.Hour,
+	}}}), srv
+}
+
+// accessClaims builds the claims of an access token issued at the given time.
+func accessClaims(userID, jti string, issued time.Time) *utils.Claims {
+	return &utils.Claims{UserId: userID, RegisteredClaims: jwt.RegisteredClaims{
+		ID:        jti,
+		IssuedAt:  jwt.NewNumericDate(issued),
+		ExpiresAt: jwt.NewNumericDate(issued.Add(15 * time.Minute)),
+	}}
+}
+
+func TestStore_RotateRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	store, srv := testStore(t)
+
+	token, err := store.IssueRefreshToken(ctx, "user-1")
+	require.NoError(t, err)
+	assert.InDelta(t, 24*time.Hour, srv.TTL("refresh:"+hashToken(token)), float64(time.Second))
+
+	userID, next, err := store.RotateRefreshToken(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+	assert.NotEqual(t, token, next)
+	assert.False(t, srv.Exists("refresh:"+hashToken(token)), "A refresh token is single-use")
+
+	userID, _, err = store.RotateRefreshToken(ctx, next)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", userID)
+
+	_, _, err = store.RotateRefreshToken(ctx, "never-issued")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestStore_RotateRefreshToken_Reuse(t *testing.T) {
+	ctx := context.Background()
+	store, _ := testStore(t)
+	before := accessClaims("user-1", "jti-1", time.Now().Add(-time.Minute))
+
+	stolen, err := store.IssueRefreshToken(ctx, "user-1")
+	require.NoError(t, err)
+	other, err := store.IssueRefreshToken(ctx, "user-1")
+	require.NoError(t, err)
+	_, next, err := store.RotateRefreshToken(ctx, stolen)
+	require.NoError(t, err)
+
+	// Replaying a rotated token revokes every session of its owner.
+	userID, _, err := store.RotateRefreshToken(ctx, stolen)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	assert.Equal(t, "user-1", userID)
+	for _, token := range []string{next, other} {
+		_, _, err = store.RotateRefreshToken(ctx, token)
+		assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	}
+	revoked, err := store.IsRevoked(ctx, before)
+	require.NoError(t, err)
+	assert.True(t, revoked, "Access tokens issued before the reuse must be revoked")
+}
+
+func TestStore_RevokeRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	store, _ := testStore(t)
+	token, err := store.IssueRefreshToken(ctx, "user-1")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, store.RevokeRefreshToken(ctx, "user-2", token), ErrInvalidRefreshToken)
+	// The owner check must not consume the token.
+	_, token, err = store.RotateRefreshToken(ctx, token)
+	require.NoError(t, err)
+
+	require.NoError(t, store.RevokeRefreshToken(ctx, "user-1", token))
+	_, _, err = store.RotateRefreshToken(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.NoError(t, store.RevokeRefreshToken(ctx, "user-1", token), "Revoking an invalid token is a no-op")
+}
+
+func TestStore_RevokeAccessToken(t *testing.T) {
+	ctx := context.Background()
+	store, srv := testStore(t)
+	claims := accessClaims("user-1", "jti-1", time.Now())
+
+	require.NoError(t, store.RevokeAccessToken(ctx, claims))
+	assert.InDelta(t, 15*time.Minute, srv.TTL("revoked:jti:jti-1"), float64(time.Second))
+	revoked, err := store.IsRevoked(ctx, claims)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = store.IsRevoked(ctx, accessClaims("user-1", "jti-2", time.Now()))
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
@@ -9,10 +9,13 @@ import (
 
 	"tx/internal/config"
 	"tx/internal/grpc"
+	"tx/internal/interceptor"
 	"tx/internal/service"
+	"tx/internal/session"
 	"tx/pkg/db"
 	"tx/pkg/logger"
 	"tx/pkg/tracer"
+	"tx/pkg/utils"
 
 	tracesdk "go.opentelemetry.io/otel/sdk/trace"
 
@@ -34,6 +37,22 @@ func main() {
 			db.NewPostgresClient,
 			// Redis
 			db.NewRedisClient,
+			// 密码哈希
+			utils.NewPasswordHasher,
+			// 用户名规则
+			utils.NewUsernamePolicy,
+			// 密码强度要求
+			utils.NewPasswordPolicy,
+			// JWT密钥
+			utils.NewJWTManager,
+			// 刷新令牌和令牌吊销
+			session.NewStore,
+			// 登录失败限制
+			session.NewLoginLimiter,
+			// 用户喜好embedding
+			service.NewEmbedder,
+			// 方法访问策略
+			interceptor.NewPolicyTable,
 			// User服务
 			service.NewUserService,
 			// System服务
@@ -0,0 +1,10 @@
+-- users.password 之前保存的是明文密码，改为保存密码哈希
+-- 明文无法在数据库中计算argon2id，这里先用pgcrypto计算bcrypt哈希，用户下次登录时会自动升级为配置的算法
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+UPDATE users
+SET password = crypt(password, gen_salt('bf', 12))
+WHERE password NOT LIKE '$argon2id$%'
+  AND password NOT LIKE '$2_$%';
+
+COMMENT ON COLUMN users.password IS '密码哈希（PHC格式），不保存明文';
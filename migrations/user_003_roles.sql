@@ -0,0 +1,6 @@
+-- 用户的角色和权限范围，登录和刷新令牌时写入访问令牌，由 auth.method_policies 检查
+ALTER TABLE users ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN users.roles IS '角色，如 admin、ops';
+COMMENT ON COLUMN users.scopes IS '权限范围，如 system:read';
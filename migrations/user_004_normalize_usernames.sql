@@ -0,0 +1,10 @@
+-- 用户名的规范形式（去掉首尾空白、NFKC规范化、大小写折叠），由服务在注册时写入
+-- Alice、alice 和 "alice " 的规范形式相同，唯一索引保证它们不能注册为不同的账号
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_normalized VARCHAR(255);
+
+-- 已有用户的规范形式必须与服务的utils.NormalizeUsername完全一致，否则这些用户无法登录，唯一索引也会漏掉冲突
+-- Postgres没有与之相同的大小写折叠（如ß、词尾的ς），因此不在SQL中回填，而是在部署新版本之前运行：
+--   go run ./cmd/backfill -usernames
+-- 然后执行 user_006_username_normalized_key.sql 加上非空约束和唯一索引
+
+COMMENT ON COLUMN users.username_normalized IS '用户名的规范形式，用于唯一性检查和登录查询';
@@ -0,0 +1,22 @@
+-- updated_at由触发器维护，UpdateProfile用它做乐观并发控制：请求带上读取时的updated_at，不一致说明资料已被修改
+UPDATE users SET updated_at = COALESCE(created_at, CURRENT_TIMESTAMP) WHERE updated_at IS NULL;
+ALTER TABLE users ALTER COLUMN updated_at SET NOT NULL;
+
+-- 使用clock_timestamp()而不是now()，同一事务内的多次修改也会得到不同的updated_at
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS trigger AS $$
+BEGIN
+    NEW.updated_at = clock_timestamp();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- 只在用户可以修改的资料变化时更新updated_at，登录时升级密码哈希、修改角色等操作不会让客户端的版本失效
+-- like_embedding由likes计算，回填任务只写入向量，同样不更新updated_at
+DROP TRIGGER IF EXISTS users_set_updated_at ON users;
+CREATE TRIGGER users_set_updated_at
+    BEFORE UPDATE OF username, likes ON users
+    FOR EACH ROW
+    WHEN ((OLD.username, OLD.likes) IS DISTINCT FROM (NEW.username, NEW.likes))
+    EXECUTE FUNCTION set_updated_at();
+
+COMMENT ON COLUMN users.updated_at IS '用户名或喜好的最后修改时间，由触发器users_set_updated_at维护';
@@ -0,0 +1,5 @@
+-- 在 go run ./cmd/backfill -usernames 为已有用户写入 username_normalized 之后执行
+-- 回填任务会报告规范形式冲突的账号，需要先人工处理，否则创建唯一索引会失败
+ALTER TABLE users ALTER COLUMN username_normalized SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_normalized_key ON users (username_normalized);
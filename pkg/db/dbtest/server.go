@@ -0,0 +1,410 @@
+// Package dbtest 提供一个最小的PostgreSQL服务端替身，按SQL返回预先录制的结果，
+// 用于在没有数据库的环境中测试经过pgx连接池和类型编解码的完整查询路径
+package dbtest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"tx/internal/config"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// VectorOID HandleVector返回的vector类型OID，扩展类型的OID由数据库分配
+const VectorOID uint32 = 16390
+
+// Column 结果列
+type Column struct {
+	Name string
+	OID  uint32
+}
+
+// Raw 录制的列值，按客户端请求的格式原样返回
+type Raw struct {
+	Text   string
+	Binary []byte
+}
+
+// Query 收到的一次查询
+type Query struct {
+	SQL          string
+	Params       [][]byte // nil表示NULL
+	ParamFormats []int16
+}
+
+// Handler 处理包含指定片段的SQL
+type Handler struct {
+	ParamOIDs []uint32
+	Columns   []Column
+	// Rows 返回结果行，值为nil表示NULL，Raw按录制的字节返回，其他值按列类型编码
+	Rows func(q Query) [][]any
+	// Tag 命令标签，为空时使用 SELECT <行数>
+	Tag string
+	// TagFunc 按查询返回命令标签，如根据参数返回 UPDATE 0 或 UPDATE 1，设置时忽略Tag
+	TagFunc func(q Query) string
+}
+
+// Server PostgreSQL服务端替身，只实现pgx用到的启动、简单查询和扩展查询协议，不做认证
+type Server struct {
+	t        testing.TB
+	listener net.Listener
+	types    *pgtype.Map
+
+	mu       sync.Mutex
+	handlers []handlerEntry
+	queries  []Query
+	conns    map[net.Conn]struct{}
+	wg       sync.WaitGroup
+}
+
+type handlerEntry struct {
+	fragment string
+	handler  Handler
+}
+
+// NewServer 在本地随机端口启动服务端替身，测试结束时关闭
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("dbtest: listen: %v", err)
+	}
+	s := &Server{t: t, listener: listener, types: pgtype.NewMap(), conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Handle 注册处理函数，SQL包含fragment（忽略空白差异）时使用，先注册的优先
+func (s *Server) Handle(fragment string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers = append(s.handlers, handlerEntry{fragment: compactSQL(fragment), handler: h})
+}
+
+// HandleVector 注册db.NewPostgresClient创建pgvector扩展和查询vector类型OID的语句
+func (s *Server) HandleVector() {
+	s.Handle("CREATE EXTENSION IF NOT EXISTS vector", Handler{Tag: "CREATE EXTENSION"})
+	s.Handle("'vector'::regtype::oid", Handler{
+		Columns: []Column{{Name: "oid", OID: pgtype.OIDOID}},
+		Rows:    func(Query) [][]any { return [][]any{{VectorOID}} },
+	})
+}
+
+// HandleTransactions 注册BEGIN、COMMIT和ROLLBACK，服务端替身不实现事务语义
+func (s *Server) HandleTransactions() {
+	s.Handle("begin", Handler{Tag: "BEGIN"})
+	s.Handle("commit", Handler{Tag: "COMMIT"})
+	s.Handle("rollback", Handler{Tag: "ROLLBACK"})
+}
+
+// Config 连接到服务端替身的配置
+func (s *Server) Config() config.PostgresConfig {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return config.PostgresConfig{
+		Host:     addr.IP.String(),
+		Port:     addr.Port,
+		User:     "test",
+		Password: "test",
+		DBName:   "test",
+		SSLMode:  "disable",
+	}
+}
+
+// Queries 返回已执行的查询
+func (s *Server) Queries() []Query {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Query(nil), s.queries...)
+}
+
+// Close 关闭监听和所有连接
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			if err := s.handleConn(conn); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.t.Logf("dbtest: %v", err)
+			}
+		}()
+	}
+}
+
+// lookup 查找SQL对应的处理函数
+func (s *Server) lookup(sql string) (Handler, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	compact := compactSQL(sql)
+	for _, e := range s.handlers {
+		if strings.Contains(compact, e.fragment) {
+			return e.handler, true
+		}
+	}
+	return Handler{}, false
+}
+
+func (s *Server) record(q Query) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries = append(s.queries, q)
+}
+
+// statement 已解析的语句
+type statement struct {
+	sql     string
+	handler Handler
+}
+
+// portal 已绑定参数的语句
+type portal struct {
+	stmt    statement
+	query   Query
+	formats []int16
+}
+
+func (s *Server) handleConn(conn net.Conn) error {
+	backend := pgproto3.NewBackend(conn, conn)
+	if err := startup(conn, backend); err != nil {
+		return err
+	}
+
+	statements := make(map[string]statement)
+	portals := make(map[string]*portal)
+	failed := false // 扩展查询出错后忽略后续消息直到Sync
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return nil
+		}
+		if failed {
+			if _, ok := msg.(*pgproto3.Sync); !ok {
+				continue
+			}
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			s.simpleQuery(backend, msg.String)
+		case *pgproto3.Parse:
+			h, ok := s.lookup(msg.Query)
+			if !ok {
+				backend.Send(unexpectedQuery(msg.Query))
+				failed = true
+				continue
+			}
+			statements[msg.Name] = statement{sql: msg.Query, handler: h}
+			backend.Send(&pgproto3.ParseComplete{})
+		case *pgproto3.Describe:
+			if msg.ObjectType == 'S' {
+				stmt := statements[msg.Name]
+				backend.Send(&pgproto3.ParameterDescription{ParameterOIDs: stmt.handler.ParamOIDs})
+				backend.Send(rowDescription(stmt.handler.Columns, nil))
+			} else if p, ok := portals[msg.Name]; ok {
+				backend.Send(rowDescription(p.stmt.handler.Columns, p.formats))
+			}
+		case *pgproto3.Bind:
+			stmt, ok := statements[msg.PreparedStatement]
+			if !ok {
+				backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "26000", Message: "prepared statement does not exist"})
+				failed = true
+				continue
+			}
+			q := Query{SQL: stmt.sql, ParamFormats: append([]int16(nil), msg.ParameterFormatCodes...)}
+			for _, p := range msg.Parameters {
+				q.Params = append(q.Params, cloneBytes(p))
+			}
+			portals[msg.DestinationPortal] = &portal{stmt: stmt, query: q, formats: append([]int16(nil), msg.ResultFormatCodes...)}
+			backend.Send(&pgproto3.BindComplete{})
+		case *pgproto3.Execute:
+			p := portals[msg.Portal]
+			s.record(p.query)
+			if err := s.sendRows(backend, p.stmt.handler, p.query, p.formats); err != nil {
+				backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: err.Error()})
+				failed = true
+			}
+		case *pgproto3.Close:
+			if msg.ObjectType == 'S' {
+				delete(statements, msg.Name)
+			} else {
+				delete(portals, msg.Name)
+			}
+			backend.Send(&pgproto3.CloseComplete{})
+		case *pgproto3.Sync:
+			failed = false
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+			if err := backend.Flush(); err != nil {
+				return err
+			}
+		case *pgproto3.Flush:
+			if err := backend.Flush(); err != nil {
+				return err
+			}
+		case *pgproto3.Terminate:
+			return nil
+		default:
+			return fmt.Errorf("unsupported message %T", msg)
+		}
+	}
+}
+
+// startup 完成启动握手，拒绝SSL并直接认证成功
+func startup(conn net.Conn, backend *pgproto3.Backend) error {
+	for {
+		msg, err := backend.ReceiveStartupMessage()
+		if err != nil {
+			return err
+		}
+		switch msg.(type) {
+		case *pgproto3.SSLRequest, *pgproto3.GSSEncRequest:
+			if _, err := conn.Write([]byte("N")); err != nil {
+				return err
+			}
+			continue
+		case *pgproto3.StartupMessage:
+		default:
+			return fmt.Errorf("unexpected startup message %T", msg)
+		}
+		backend.Send(&pgproto3.AuthenticationOk{})
+		backend.Send(&pgproto3.ParameterStatus{Name: "server_version", Value: "16.0"})
+		backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+		backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+		backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		return backend.Flush()
+	}
+}
+
+// simpleQuery 处理简单查询协议，结果使用文本格式
+func (s *Server) simpleQuery(backend *pgproto3.Backend, sql string) {
+	defer func() {
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		backend.Flush()
+	}()
+	if strings.HasPrefix(strings.TrimSpace(sql), "--") {
+		backend.Send(&pgproto3.EmptyQueryResponse{})
+		return
+	}
+	h, ok := s.lookup(sql)
+	if !ok {
+		backend.Send(unexpectedQuery(sql))
+		return
+	}
+	q := Query{SQL: sql}
+	s.record(q)
+	if len(h.Columns) > 0 {
+		backend.Send(rowDescription(h.Columns, nil))
+	}
+	if err := s.sendRows(backend, h, q, nil); err != nil {
+		backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: err.Error()})
+	}
+}
+
+// sendRows 按请求的格式编码结果行
+func (s *Server) sendRows(backend *pgproto3.Backend, h Handler, q Query, formats []int16) error {
+	var rows [][]any
+	if h.Rows != nil {
+		rows = h.Rows(q)
+	}
+	for _, row := range rows {
+		if len(row) != len(h.Columns) {
+			return fmt.Errorf("row has %d values for %d columns", len(row), len(h.Columns))
+		}
+		values := make([][]byte, len(row))
+		for i, v := range row {
+			encoded, err := s.encode(h.Columns[i].OID, formatCode(formats, i), v)
+			if err != nil {
+				return fmt.Errorf("encode column %s: %w", h.Columns[i].Name, err)
+			}
+			values[i] = encoded
+		}
+		backend.Send(&pgproto3.DataRow{Values: values})
+	}
+	tag := h.Tag
+	if h.TagFunc != nil {
+		tag = h.TagFunc(q)
+	}
+	if tag == "" {
+		tag = fmt.Sprintf("SELECT %d", len(rows))
+	}
+	backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+	return nil
+}
+
+func (s *Server) encode(oid uint32, format int16, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case Raw:
+		if format == pgtype.BinaryFormatCode {
+			return v.Binary, nil
+		}
+		return []byte(v.Text), nil
+	}
+	return s.types.Encode(oid, format, v, nil)
+}
+
+// rowDescription 没有结果列时返回NoData
+func rowDescription(columns []Column, formats []int16) pgproto3.BackendMessage {
+	if len(columns) == 0 {
+		return &pgproto3.NoData{}
+	}
+	fields := make([]pgproto3.FieldDescription, len(columns))
+	for i, c := range columns {
+		fields[i] = pgproto3.FieldDescription{Name: []byte(c.Name), DataTypeOID: c.OID, DataTypeSize: -1, TypeModifier: -1, Format: formatCode(formats, i)}
+	}
+	return &pgproto3.RowDescription{Fields: fields}
+}
+
+// formatCode 按Bind的规则取第i列的格式：没有指定时为文本，只指定一个时用于所有列
+func formatCode(formats []int16, i int) int16 {
+	switch len(formats) {
+	case 0:
+		return pgtype.TextFormatCode
+	case 1:
+		return formats[0]
+	default:
+		return formats[i]
+	}
+}
+
+func unexpectedQuery(sql string) *pgproto3.ErrorResponse {
+	return &pgproto3.ErrorResponse{Severity: "ERROR", Code: "42601", Message: "dbtest: unexpected query: " + sql}
+}
+
+// compactSQL 合并连续空白，使SQL片段的匹配不受缩进影响
+func compactSQL(sql string) string {
+	return strings.Join(strings.Fields(sql), " ")
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
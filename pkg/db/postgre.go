@@ -6,6 +6,7 @@ import (
 
 	"tx/internal/config"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -28,6 +29,13 @@ func NewPostgresClient(cfg *config.Config) (*pgxpool.Pool, error) {
 
 	// 连接池可以在这里进行更多配置
 	config.MaxConns = 10
+	// 每个新连接都注册vector类型，like_embedding才能与[]float32互相转换
+	config.AfterConnect = RegisterVectorType
+
+	// 初始化向量扩展，必须在连接池建立连接之前完成，否则查不到vector类型
+	if err := createVectorExtension(context.Background(), config.ConnConfig); err != nil {
+		return nil, err
+	}
 
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
@@ -36,13 +44,20 @@ func NewPostgresClient(cfg *config.Config) (*pgxpool.Pool, error) {
 
 	// 验证连接
 	if err := pool.Ping(context.Background()); err != nil {
-		return nil, err
-	}
-
-	// 初始化向量扩展
-	if _, err := pool.Exec(context.Background(), "CREATE EXTENSION IF NOT EXISTS vector"); err != nil {
+		pool.Close()
 		return nil, err
 	}
 
 	return pool, nil
 }
+
+// createVectorExtension 使用单独的连接创建pgvector扩展
+func createVectorExtension(ctx context.Context, connConfig *pgx.ConnConfig) error {
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(ctx)
+	_, err = conn.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS vector")
+	return err
+}
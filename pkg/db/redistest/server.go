@@ -0,0 +1,642 @@
+// Package redistest 提供一个内存中的Redis服务端替身，实现服务用到的字符串、集合、有序集合、过期和事务命令，
+// 用于在没有Redis的环境中通过go-redis客户端测试完整的命令路径
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+)
+
+// Server Redis服务端替身，只支持RESP2，不做认证，所有连接共享同一个数据库
+type Server struct {
+	t        testing.TB
+	listener net.Listener
+
+	mu     sync.Mutex
+	data   map[string]*entry
+	offset time.Duration // FastForward累计前进的时间
+	conns  map[net.Conn]struct{}
+	wg     sync.WaitGroup
+}
+
+// entry 一个键的值，str、set和zset只有一个不为nil
+type entry struct {
+	str      *string
+	set      map[string]struct{}
+	zset     map[string]float64
+	expireAt time.Time // 零值表示不过期
+}
+
+// NewServer 在本地随机端口启动服务端替身，测试结束时关闭
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("redistest: listen: %v", err)
+	}
+	s := &Server{t: t, listener: listener, data: make(map[string]*entry), conns: make(map[net.Conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Config 连接到服务端替身的配置
+func (s *Server) Config() config.RedisConfig {
+	return config.RedisConfig{Address: s.listener.Addr().String()}
+}
+
+// Get 返回字符串键的值
+func (s *Server) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil || e.str == nil {
+		return "", false
+	}
+	return *e.str, true
+}
+
+// Set 写入不过期的字符串键
+func (s *Server) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = &entry{str: &value}
+}
+
+// Exists 键是否存在且未过期
+func (s *Server) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lookup(key) != nil
+}
+
+// TTL 返回键的剩余有效期，键不存在或不过期时返回0
+func (s *Server) TTL(key string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.lookup(key)
+	if e == nil || e.expireAt.IsZero() {
+		return 0
+	}
+	return e.expireAt.Sub(s.now())
+}
+
+// Keys 返回所有未过期的键，按字典序排列
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.data))
+	for key := range s.data {
+		if s.lookup(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FastForward 让服务端的时钟前进d，到期的键随之过期
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset += d
+}
+
+// Close 关闭监听和所有连接
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			if err := s.handleConn(conn); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.t.Logf("redistest: %v", err)
+			}
+		}()
+	}
+}
+
+// now 服务端时钟，用于判断键是否过期
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+// lookup 返回未过期的键，已过期的键在这里删除，调用方需持有锁
+func (s *Server) lookup(key string) *entry {
+	e, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !e.expireAt.IsZero() && !s.now().Before(e.expireAt) {
+		delete(s.data, key)
+		return nil
+	}
+	return e
+}
+
+// 回复类型，string按bulk string编码
+type (
+	simpleString string
+	errorReply   string
+	nilReply     struct{}
+)
+
+var (
+	okReply       = simpleString("OK")
+	wrongTypeErr  = errorReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	syntaxErr     = errorReply("ERR syntax error")
+	notIntegerErr = errorReply("ERR value is not an integer or out of range")
+	notFloatErr   = errorReply("ERR min or max is not a float")
+)
+
+func (s *Server) handleConn(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var queued [][]string // MULTI之后排队的命令
+	inMulti := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return err
+		}
+		var reply any
+		switch name := strings.ToUpper(args[0]); {
+		case name == "MULTI":
+			if inMulti {
+				reply = errorReply("ERR MULTI calls can not be nested")
+				break
+			}
+			inMulti, queued, reply = true, nil, okReply
+		case name == "EXEC":
+			if !inMulti {
+				reply = errorReply("ERR EXEC without MULTI")
+				break
+			}
+			// 持有锁执行所有命令，其他连接看不到中间状态
+			s.mu.Lock()
+			replies := make([]any, len(queued))
+			for i, cmd := range queued {
+				replies[i] = s.exec(cmd)
+			}
+			s.mu.Unlock()
+			inMulti, queued, reply = false, nil, replies
+		case name == "DISCARD":
+			if !inMulti {
+				reply = errorReply("ERR DISCARD without MULTI")
+				break
+			}
+			inMulti, queued, reply = false, nil, okReply
+		case inMulti:
+			queued = append(queued, args)
+			reply = simpleString("QUEUED")
+		default:
+			s.mu.Lock()
+			reply = s.exec(args)
+			s.mu.Unlock()
+		}
+		writeReply(w, reply)
+		// 客户端使用流水线时一次发送多条命令，读完缓冲区中的命令后再发送回复
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// exec 执行一条命令，调用方需持有锁
+func (s *Server) exec(args []string) any {
+	name, args := strings.ToUpper(args[0]), args[1:]
+	switch name {
+	case "PING":
+		return simpleString("PONG")
+	case "HELLO":
+		// 返回错误使go-redis退回RESP2
+		return errorReply("ERR unknown command 'HELLO'")
+	case "CLIENT", "SELECT":
+		return okReply
+	case "FLUSHALL", "FLUSHDB":
+		s.data = make(map[string]*entry)
+		return okReply
+	case "GET":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		return s.getString(args[0])
+	case "GETDEL":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		reply := s.getString(args[0])
+		if _, ok := reply.(string); ok {
+			delete(s.data, args[0])
+		}
+		return reply
+	case "MGET":
+		if len(args) == 0 {
+			return wrongArgs(name)
+		}
+		replies := make([]any, len(args))
+		for i, key := range args {
+			replies[i] = nilReply{}
+			if e := s.lookup(key); e != nil && e.str != nil {
+				replies[i] = *e.str
+			}
+		}
+		return replies
+	case "SET":
+		return s.set(args)
+	case "DEL", "EXISTS":
+		if len(args) == 0 {
+			return wrongArgs(name)
+		}
+		var n int64
+		for _, key := range args {
+			if s.lookup(key) != nil {
+				n++
+				if name == "DEL" {
+					delete(s.data, key)
+				}
+			}
+		}
+		return n
+	case "EXPIRE", "PEXPIRE":
+		if len(args) != 2 {
+			return wrongArgs(name)
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return notIntegerErr
+		}
+		unit := time.Second
+		if name == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		e := s.lookup(args[0])
+		if e == nil {
+			return int64(0)
+		}
+		if n <= 0 {
+			delete(s.data, args[0])
+			return int64(1)
+		}
+		e.expireAt = s.now().Add(time.Duration(n) * unit)
+		return int64(1)
+	case "TTL", "PTTL":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		e := s.lookup(args[0])
+		switch {
+		case e == nil:
+			return int64(-2)
+		case e.expireAt.IsZero():
+			return int64(-1)
+		}
+		remaining := e.expireAt.Sub(s.now())
+		if name == "TTL" {
+			return int64((remaining + time.Second - 1) / time.Second)
+		}
+		return int64((remaining + time.Millisecond - 1) / time.Millisecond)
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			return wrongArgs(name)
+		}
+		e, reply := s.setEntry(args[0], name == "SADD")
+		if reply != nil {
+			return reply
+		}
+		var n int64
+		for _, member := range args[1:] {
+			_, ok := e.set[member]
+			if name == "SADD" && !ok {
+				e.set[member] = struct{}{}
+				n++
+			} else if name == "SREM" && ok {
+				delete(e.set, member)
+				n++
+			}
+		}
+		s.dropEmpty(args[0], e)
+		return n
+	case "SMEMBERS":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		e, reply := s.setEntry(args[0], false)
+		if reply != nil {
+			return reply
+		}
+		members := make([]string, 0, len(e.set))
+		for member := range e.set {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		replies := make([]any, len(members))
+		for i, member := range members {
+			replies[i] = member
+		}
+		return replies
+	case "ZADD":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return syntaxErr
+		}
+		e, reply := s.zsetEntry(args[0], true)
+		if reply != nil {
+			return reply
+		}
+		var n int64
+		for i := 1; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil {
+				return errorReply("ERR value is not a valid float")
+			}
+			if _, ok := e.zset[args[i+1]]; !ok {
+				n++
+			}
+			e.zset[args[i+1]] = score
+		}
+		return n
+	case "ZCARD":
+		if len(args) != 1 {
+			return wrongArgs(name)
+		}
+		e, reply := s.zsetEntry(args[0], false)
+		if reply != nil {
+			return reply
+		}
+		return int64(len(e.zset))
+	case "ZCOUNT", "ZREMRANGEBYSCORE":
+		if len(args) != 3 {
+			return wrongArgs(name)
+		}
+		lo, ok1 := parseScoreBound(args[1])
+		hi, ok2 := parseScoreBound(args[2])
+		if !ok1 || !ok2 {
+			return notFloatErr
+		}
+		e, reply := s.zsetEntry(args[0], false)
+		if reply != nil {
+			return reply
+		}
+		var n int64
+		for member, score := range e.zset {
+			if lo.below(score) && hi.above(score) {
+				n++
+				if name == "ZREMRANGEBYSCORE" {
+					delete(e.zset, member)
+				}
+			}
+		}
+		s.dropEmpty(args[0], e)
+		return n
+	}
+	return errorReply(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(name)))
+}
+
+// getString 读取字符串键，不存在时返回nil
+func (s *Server) getString(key string) any {
+	e := s.lookup(key)
+	if e == nil {
+		return nilReply{}
+	}
+	if e.str == nil {
+		return wrongTypeErr
+	}
+	return *e.str
+}
+
+// set 实现 SET key value [NX|XX] [EX seconds|PX milliseconds|KEEPTTL]
+func (s *Server) set(args []string) any {
+	if len(args) < 2 {
+		return wrongArgs("SET")
+	}
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	var nx, xx, keepTTL bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return syntaxErr
+			}
+			i++
+			n, err := strconv.ParseInt(args[i], 10, 64)
+			if err != nil {
+				return notIntegerErr
+			}
+			if n <= 0 {
+				return errorReply("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			ttl = time.Duration(n) * unit
+		default:
+			return syntaxErr
+		}
+	}
+	old := s.lookup(key)
+	if (nx && old != nil) || (xx && old == nil) {
+		return nilReply{}
+	}
+	e := &entry{str: &value}
+	if ttl > 0 {
+		e.expireAt = s.now().Add(ttl)
+	} else if keepTTL && old != nil {
+		e.expireAt = old.expireAt
+	}
+	s.data[key] = e
+	return okReply
+}
+
+// setEntry 返回集合键，create为false且键不存在时返回空集合
+func (s *Server) setEntry(key string, create bool) (*entry, any) {
+	e := s.lookup(key)
+	if e == nil {
+		e = &entry{set: make(map[string]struct{})}
+		if create {
+			s.data[key] = e
+		}
+		return e, nil
+	}
+	if e.set == nil {
+		return nil, wrongTypeErr
+	}
+	return e, nil
+}
+
+// zsetEntry 返回有序集合键，create为false且键不存在时返回空集合
+func (s *Server) zsetEntry(key string, create bool) (*entry, any) {
+	e := s.lookup(key)
+	if e == nil {
+		e = &entry{zset: make(map[string]float64)}
+		if create {
+			s.data[key] = e
+		}
+		return e, nil
+	}
+	if e.zset == nil {
+		return nil, wrongTypeErr
+	}
+	return e, nil
+}
+
+// dropEmpty 与Redis一致，集合为空时删除键
+func (s *Server) dropEmpty(key string, e *entry) {
+	if (e.set != nil && len(e.set) == 0) || (e.zset != nil && len(e.zset) == 0) {
+		if s.data[key] == e {
+			delete(s.data, key)
+		}
+	}
+}
+
+// scoreBound 分数范围的一端，"(" 前缀表示不包含该值
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(arg string) (scoreBound, bool) {
+	var b scoreBound
+	if strings.HasPrefix(arg, "(") {
+		b.exclusive, arg = true, arg[1:]
+	}
+	switch strings.ToLower(arg) {
+	case "-inf":
+		b.value = math.Inf(-1)
+	case "+inf", "inf":
+		b.value = math.Inf(1)
+	default:
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return b, false
+		}
+		b.value = v
+	}
+	return b, true
+}
+
+// below 作为下界时score是否在范围内
+func (b scoreBound) below(score float64) bool {
+	if b.exclusive {
+		return b.value < score
+	}
+	return b.value <= score
+}
+
+// above 作为上界时score是否在范围内
+func (b scoreBound) above(score float64) bool {
+	if b.exclusive {
+		return score < b.value
+	}
+	return score <= b.value
+}
+
+func wrongArgs(name string) errorReply {
+	return errorReply(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// readCommand 读取一条命令，客户端总是以bulk string数组发送
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected request %q", line)
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid array length %q", line)
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("unexpected argument %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// writeReply 按RESP2编码回复
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case simpleString:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case errorReply:
+		fmt.Fprintf(w, "-%s\r\n", v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case nilReply:
+		w.WriteString("$-1\r\n")
+	case []any:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("redistest: unsupported reply %T", reply))
+	}
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// vectorHeaderSize pgvector二进制格式的头部：维度（int16）和保留字段（int16）
+const vectorHeaderSize = 4
+
+// VectorCodec pgvector的vector类型与[]float32之间的编解码
+// 二进制格式为维度、保留字段和大端序float4，文本格式为 [1,2,3]，NULL对应nil
+type VectorCodec struct{}
+
+// RegisterVectorType 查询vector类型的OID并在连接上注册VectorCodec
+// vector由扩展创建，每个数据库的OID不同，需要在每个新连接上注册
+func RegisterVectorType(ctx context.Context, conn *pgx.Conn) error {
+	var oid uint32
+	if err := conn.QueryRow(ctx, "SELECT 'vector'::regtype::oid").Scan(&oid); err != nil {
+		return fmt.Errorf("look up vector type: %w", err)
+	}
+	conn.TypeMap().RegisterType(&pgtype.Type{Name: "vector", OID: oid, Codec: VectorCodec{}})
+	return nil
+}
+
+// FormatSupported 支持文本和二进制格式
+func (VectorCodec) FormatSupported(format int16) bool {
+	return format == pgtype.TextFormatCode || format == pgtype.BinaryFormatCode
+}
+
+// PreferredFormat 二进制格式不需要解析浮点数文本
+func (VectorCodec) PreferredFormat() int16 {
+	return pgtype.BinaryFormatCode
+}
+
+// PlanEncode 只支持编码[]float32
+func (VectorCodec) PlanEncode(m *pgtype.Map, oid uint32, format int16, value any) pgtype.EncodePlan {
+	if _, ok := value.([]float32); !ok {
+		return nil
+	}
+	switch format {
+	case pgtype.BinaryFormatCode:
+		return encodeVectorBinary{}
+	case pgtype.TextFormatCode:
+		return encodeVectorText{}
+	}
+	return nil
+}
+
+// PlanScan 只支持扫描到*[]float32
+func (VectorCodec) PlanScan(m *pgtype.Map, oid uint32, format int16, target any) pgtype.ScanPlan {
+	if _, ok := target.(*[]float32); !ok {
+		return nil
+	}
+	switch format {
+	case pgtype.BinaryFormatCode:
+		return scanVector(decodeVectorBinary)
+	case pgtype.TextFormatCode:
+		return scanVector(decodeVectorText)
+	}
+	return nil
+}
+
+// DecodeDatabaseSQLValue database/sql中使用文本形式
+func (c VectorCodec) DecodeDatabaseSQLValue(m *pgtype.Map, oid uint32, format int16, src []byte) (driver.Value, error) {
+	if src == nil {
+		return nil, nil
+	}
+	if format == pgtype.TextFormatCode {
+		return string(src), nil
+	}
+	vec, err := decodeVectorBinary(src)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := appendVectorText(nil, vec)
+	return string(buf), err
+}
+
+// DecodeValue 解码为[]float32，NULL返回nil
+func (c VectorCodec) DecodeValue(m *pgtype.Map, oid uint32, format int16, src []byte) (any, error) {
+	if src == nil {
+		return nil, nil
+	}
+	if format == pgtype.TextFormatCode {
+		return decodeVectorText(src)
+	}
+	return decodeVectorBinary(src)
+}
+
+type encodeVectorBinary struct{}
+
+func (encodeVectorBinary) Encode(value any, buf []byte) ([]byte, error) {
+	vec := value.([]float32)
+	if vec == nil {
+		return nil, nil
+	}
+	if err := checkVector(vec); err != nil {
+		return nil, err
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(vec)))
+	buf = binary.BigEndian.AppendUint16(buf, 0)
+	for _, v := range vec {
+		buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(v))
+	}
+	return buf, nil
+}
+
+type encodeVectorText struct{}
+
+func (encodeVectorText) Encode(value any, buf []byte) ([]byte, error) {
+	vec := value.([]float32)
+	if vec == nil {
+		return nil, nil
+	}
+	return appendVectorText(buf, vec)
+}
+
+// appendVectorText 追加 [1,2,3] 形式的文本，使用能精确还原float32的最短表示
+func appendVectorText(buf []byte, vec []float32) ([]byte, error) {
+	if err := checkVector(vec); err != nil {
+		return nil, err
+	}
+	buf = append(buf, '[')
+	for i, v := range vec {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendFloat(buf, float64(v), 'g', -1, 32)
+	}
+	return append(buf, ']'), nil
+}
+
+// checkVector pgvector不接受空向量、超过int16的维度和非有限值
+func checkVector(vec []float32) error {
+	if len(vec) == 0 || len(vec) > math.MaxInt16 {
+		return fmt.Errorf("vector must have between 1 and %d dimensions, got %d", math.MaxInt16, len(vec))
+	}
+	for _, v := range vec {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return errors.New("vector must not contain NaN or infinity")
+		}
+	}
+	return nil
+}
+
+// scanVector 将解码函数包装为ScanPlan，NULL扫描为nil
+type scanVector func(src []byte) ([]float32, error)
+
+func (decode scanVector) Scan(src []byte, target any) error {
+	dst := target.(*[]float32)
+	if src == nil {
+		*dst = nil
+		return nil
+	}
+	vec, err := decode(src)
+	if err != nil {
+		return err
+	}
+	*dst = vec
+	return nil
+}
+
+// decodeVectorBinary 解析vector_send的输出
+func decodeVectorBinary(src []byte) ([]float32, error) {
+	if len(src) < vectorHeaderSize {
+		return nil, fmt.Errorf("invalid vector: %d bytes is shorter than the header", len(src))
+	}
+	dim := int(binary.BigEndian.Uint16(src))
+	if len(src) != vectorHeaderSize+4*dim {
+		return nil, fmt.Errorf("invalid vector: %d bytes for %d dimensions", len(src), dim)
+	}
+	vec := make([]float32, dim)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.BigEndian.Uint32(src[vectorHeaderSize+4*i:]))
+	}
+	return vec, nil
+}
+
+// decodeVectorText 解析 [1,2,3] 形式的文本
+func decodeVectorText(src []byte) ([]float32, error) {
+	s := strings.TrimSpace(string(src))
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil, fmt.Errorf("invalid vector %q", s)
+	}
+	fields := strings.Split(s[1:len(s)-1], ",")
+	vec := make([]float32, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector %q: %w", s, err)
+		}
+		vec[i] = float32(v)
+	}
+	return vec, nil
+}
@@ -0,0 +1,144 @@
+package db
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"tx/internal/config"
+	"tx/pkg/db/dbtest"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Recorded from PostgreSQL 16 with pgvector 0.7: SELECT '[1,-2.5,0.25]'::vector
+// in binary (vector_send) and text (vector_out) format.
+var (
+	recordedVector       = []float32{1, -2.5, 0.25}
+	recordedVectorBinary = []byte{
+		0x00, 0x03, 0x00, 0x00,
+		0x3f, 0x80, 0x00, 0x00,
+		0xc0, 0x20, 0x00, 0x00,
+		0x3e, 0x80, 0x00, 0x00,
+	}
+	recordedVectorText = "[1,-2.5,0.25]"
+)
+
+func vectorTypeMap() *pgtype.Map {
+	m := pgtype.NewMap()
+	m.RegisterType(&pgtype.Type{Name: "vector", OID: dbtest.VectorOID, Codec: VectorCodec{}})
+	return m
+}
+
+func TestVectorCodec_Encode(t *testing.T) {
+	m := vectorTypeMap()
+
+	buf, err := m.Encode(dbtest.VectorOID, pgtype.BinaryFormatCode, recordedVector, nil)
+	require.NoError(t, err)
+	assert.Equal(t, recordedVectorBinary, buf)
+
+	buf, err = m.Encode(dbtest.VectorOID, pgtype.TextFormatCode, recordedVector, nil)
+	require.NoError(t, err)
+	assert.Equal(t, recordedVectorText, string(buf))
+
+	buf, err = m.Encode(dbtest.VectorOID, pgtype.BinaryFormatCode, []float32(nil), nil)
+	require.NoError(t, err)
+	assert.Nil(t, buf, "nil vector should be encoded as NULL")
+
+	for name, vec := range map[string][]float32{
+		"Empty": {},
+		"NaN":   {1, float32(math.NaN())},
+		"Inf":   {float32(math.Inf(-1))},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := m.Encode(dbtest.VectorOID, pgtype.BinaryFormatCode, vec, nil)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestVectorCodec_Scan(t *testing.T) {
+	m := vectorTypeMap()
+	tests := []struct {
+		name   string
+		format int16
+		src    []byte
+		want   []float32
+		err    bool
+	}{
+		{"Binary", pgtype.BinaryFormatCode, recordedVectorBinary, recordedVector, false},
+		{"Text", pgtype.TextFormatCode, []byte(recordedVectorText), recordedVector, false},
+		{"TextWithSpaces", pgtype.TextFormatCode, []byte("[ 1, -2.5 ,0.25 ]"), recordedVector, false},
+		{"BinaryNull", pgtype.BinaryFormatCode, nil, nil, false},
+		{"TextNull", pgtype.TextFormatCode, nil, nil, false},
+		{"BinaryTruncated", pgtype.BinaryFormatCode, recordedVectorBinary[:10], nil, true},
+		{"BinaryShortHeader", pgtype.BinaryFormatCode, []byte{0x00}, nil, true},
+		{"TextMissingBrackets", pgtype.TextFormatCode, []byte("1,2"), nil, true},
+		{"TextBadNumber", pgtype.TextFormatCode, []byte("[1,x]"), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []float32{42} // NULL must reset the destination
+			err := m.Scan(dbtest.VectorOID, tt.format, tt.src, &got)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewPostgresClient_Vector(t *testing.T) {
+	srv := dbtest.NewServer(t)
+	srv.HandleVector()
+	srv.Handle("SELECT likes, like_embedding FROM users WHERE id = $1", dbtest.Handler{
+		ParamOIDs: []uint32{pgtype.TextOID},
+		Columns:   []dbtest.Column{{Name: "likes", OID: pgtype.TextOID}, {Name: "like_embedding", OID: dbtest.VectorOID}},
+		Rows: func(q dbtest.Query) [][]any {
+			if string(q.Params[0]) == "with-likes" {
+				return [][]any{{"hiking", dbtest.Raw{Text: recordedVectorText, Binary: recordedVectorBinary}}}
+			}
+			return [][]any{{nil, nil}}
+		},
+	})
+	srv.Handle("SELECT $1::vector", dbtest.Handler{
+		ParamOIDs: []uint32{dbtest.VectorOID},
+		Columns:   []dbtest.Column{{Name: "vector", OID: dbtest.VectorOID}},
+		Rows: func(q dbtest.Query) [][]any {
+			return [][]any{{dbtest.Raw{Binary: q.Params[0]}}}
+		},
+	})
+
+	pool, err := NewPostgresClient(&config.Config{Postgres: srv.Config()})
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	ctx := context.Background()
+
+	var likes *string
+	var embedding []float32
+	require.NoError(t, pool.QueryRow(ctx, "SELECT likes, like_embedding FROM users WHERE id = $1", "with-likes").Scan(&likes, &embedding))
+	require.NotNil(t, likes)
+	assert.Equal(t, "hiking", *likes)
+	assert.Equal(t, recordedVector, embedding)
+
+	require.NoError(t, pool.QueryRow(ctx, "SELECT likes, like_embedding FROM users WHERE id = $1", "without-likes").Scan(&likes, &embedding))
+	assert.Nil(t, likes)
+	assert.Nil(t, embedding)
+
+	// The parameter is sent in binary and echoed back by the stand-in.
+	require.NoError(t, pool.QueryRow(ctx, "SELECT $1::vector", recordedVector).Scan(&embedding))
+	assert.Equal(t, recordedVector, embedding)
+
+	var sawExtension bool
+	for _, q := range srv.Queries() {
+		if q.SQL == "SELECT $1::vector" {
+			assert.Equal(t, recordedVectorBinary, q.Params[0])
+		}
+		sawExtension = sawExtension || q.SQL == "CREATE EXTENSION IF NOT EXISTS vector"
+	}
+	assert.True(t, sawExtension)
+}
@@ -1,14 +1,23 @@
 package logger
 
 import (
+	"os"
+	"path/filepath"
+
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// FilePath 日志文件路径，所在目录默认是system.file_roots之一，可以通过TailFile跟踪
+const FilePath = "./logs/app.log"
+
 // NewLogger 创建zap日志器
 func NewLogger() (*zap.Logger, error) {
+	if err := os.MkdirAll(filepath.Dir(FilePath), 0o755); err != nil {
+		return nil, err
+	}
 	config := zap.NewProductionConfig()
 	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
-	config.OutputPaths = []string{"stdout", "./app.log"} // 输出到标准输出
+	config.OutputPaths = []string{"stdout", FilePath} // 输出到标准输出和日志文件
 	return config.Build()
 }
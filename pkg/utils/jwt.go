@@ -1,43 +1,284 @@
 package utils
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
 	"time"
 
+	"tx/internal/config"
+
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte("adgihioasxbfjkcbAEWIOFGHBIOHasegfWEAWEgWEARx")
+// 支持的JWT签名算法
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	minHMACSecretLength = 32   // HS256密钥的最小字节数
+	minRSAKeyBits       = 2048 // RS256密钥的最小位数
+)
 
+// Claims 访问令牌的声明，user_id与sub相同，为注册时生成的用户ID
 type Claims struct {
-	UserId string `json:"user_id"`
+	UserId   string   `json:"user_id"`
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func GenerateToken(userId string) (string, error) {
-	// 生成token
+// JWK 公开的JSON Web Key，字段含义见RFC 7517和RFC 8037
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`   // RSA模数
+	E   string `json:"e,omitempty"`   // RSA公钥指数
+	Crv string `json:"crv,omitempty"` // OKP曲线
+	X   string `json:"x,omitempty"`   // OKP公钥
+}
+
+// JWTManager 使用配置中的密钥签发和校验JWT
+// 新token使用signing_key签发并在头部写入kid，校验时按kid查找密钥，因此轮换后旧密钥签发的token在过期前仍然有效
+type JWTManager struct {
+	issuer  string
+	ttl     time.Duration
+	signing *jwtKey
+	keys    map[string]*jwtKey
+	methods []string
+}
+
+// jwtKey 一个签名密钥
+type jwtKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   any // 只用于校验的密钥为nil
+	verifyKey any
+}
+
+// NewJWTManager 根据配置加载JWT密钥
+func NewJWTManager(cfg *config.Config) (*JWTManager, error) {
+	jc := cfg.Auth.JWT
+	if jc.AccessTokenTTL <= 0 {
+		return nil, fmt.Errorf("invalid access token ttl %v", jc.AccessTokenTTL)
+	}
+	m := &JWTManager{
+		issuer: jc.Issuer,
+		ttl:    jc.AccessTokenTTL,
+		keys:   make(map[string]*jwtKey, len(jc.Keys)),
+	}
+	for _, kc := range jc.Keys {
+		if kc.KID == "" {
+			return nil, errors.New("jwt key without kid")
+		}
+		if _, ok := m.keys[kc.KID]; ok {
+			return nil, fmt.Errorf("duplicate jwt key %q", kc.KID)
+		}
+		key, err := loadJWTKey(kc)
+		if err != nil {
+			return nil, fmt.Errorf("load jwt key %q: %w", kc.KID, err)
+		}
+		m.keys[kc.KID] = key
+		m.methods = append(m.methods, key.method.Alg())
+	}
+
+	signing, ok := m.keys[jc.SigningKey]
+	if !ok {
+		return nil, fmt.Errorf("jwt signing key %q is not configured", jc.SigningKey)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("jwt signing key %q has no private key", jc.SigningKey)
+	}
+	m.signing = signing
+	return m, nil
+}
+
+// loadJWTKey 读取密钥材料，HS256密钥来自环境变量或文件，RS256和EdDSA密钥来自PEM文件
+func loadJWTKey(kc config.JWTKeyConfig) (*jwtKey, error) {
+	key := &jwtKey{kid: kc.KID}
+	switch kc.Algorithm {
+	case JWTAlgorithmHS256:
+		key.method = jwt.SigningMethodHS256
+		secret, err := readSecret(kc)
+		if err != nil {
+			return nil, err
+		}
+		if len(secret) < minHMACSecretLength {
+			return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minHMACSecretLength)
+		}
+		key.signKey, key.verifyKey = secret, secret
+	case JWTAlgorithmRS256:
+		key.method = jwt.SigningMethodRS256
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey, key.verifyKey = private, &private.PublicKey
+		} else {
+			pem, err := readPublicKey(kc)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+		if bits := key.verifyKey.(*rsa.PublicKey).N.BitLen(); bits < minRSAKeyBits {
+			return nil, fmt.Errorf("RS256 key must be at least %d bits, got %d", minRSAKeyBits, bits)
+		}
+	case JWTAlgorithmEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+		if kc.PrivateKeyFile != "" {
+			pem, err := os.ReadFile(kc.PrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+			private, err := jwt.ParseEdPrivateKeyFromPEM(pem)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey, key.verifyKey = private, private.(ed25519.PrivateKey).Public()
+		} else {
+			pem, err := readPublicKey(kc)
+			if err != nil {
+				return nil, err
+			}
+			if key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(pem); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", kc.Algorithm)
+	}
+	return key, nil
+}
+
+// readSecret 读取HS256密钥，环境变量优先
+func readSecret(kc config.JWTKeyConfig) ([]byte, error) {
+	if kc.SecretEnv != "" {
+		if secret := os.Getenv(kc.SecretEnv); secret != "" {
+			return []byte(secret), nil
+		}
+		if kc.SecretFile == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", kc.SecretEnv)
+		}
+	}
+	if kc.SecretFile == "" {
+		return nil, errors.New("HS256 key requires secret_env or secret_file")
+	}
+	secret, err := os.ReadFile(kc.SecretFile)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimSpace(string(secret))), nil
+}
+
+// readPublicKey 读取只用于校验的公钥
+func readPublicKey(kc config.JWTKeyConfig) ([]byte, error) {
+	if kc.PublicKeyFile == "" {
+		return nil, fmt.Errorf("%s key requires private_key_file or public_key_file", kc.Algorithm)
+	}
+	return os.ReadFile(kc.PublicKeyFile)
+}
+
+// AccessTokenTTL 访问令牌的有效期
+func (m *JWTManager) AccessTokenTTL() time.Duration {
+	return m.ttl
+}
+
+// GenerateToken 使用当前签名密钥签发token，每个token有唯一的jti用于吊销
+func (m *JWTManager) GenerateToken(userId, username string, roles, scopes []string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
 	claims := Claims{
-		userId,
-		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 过期时间24小时
-			IssuedAt:  jwt.NewNumericDate(time.Now()),                     // 签发时间
-			NotBefore: jwt.NewNumericDate(time.Now()),                     // 生效时间
+		UserId:   userId,
+		Username: username,
+		Roles:    roles,
+		Scopes:   scopes,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(jti),
+			Issuer:    m.issuer,
+			Subject:   userId,
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.ttl)), // 过期时间
+			IssuedAt:  jwt.NewNumericDate(now),            // 签发时间
+			NotBefore: jwt.NewNumericDate(now),            // 生效时间
 		},
 	}
-	// 使用HS256签名算法
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	s, err := t.SignedString([]byte(jwtKey))
-
-	return s, err
+	t := jwt.NewWithClaims(m.signing.method, claims)
+	t.Header["kid"] = m.signing.kid
+	return t.SignedString(m.signing.signKey)
 }
 
-func ParseToken(tokenstring string) (*Claims, error) {
+// ParseToken 按头部的kid选择密钥校验token，签名算法必须与该密钥的算法一致
+func (m *JWTManager) ParseToken(tokenstring string) (*Claims, error) {
+	options := []jwt.ParserOption{jwt.WithValidMethods(m.methods), jwt.WithExpirationRequired()}
+	if m.issuer != "" {
+		options = append(options, jwt.WithIssuer(m.issuer))
+	}
 	t, err := jwt.ParseWithClaims(tokenstring, &Claims{}, func(token *jwt.Token) (any, error) {
-		return jwtKey, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("signing method %s does not match key %q", token.Method.Alg(), kid)
+		}
+		return key.verifyKey, nil
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
 
 	if claims, ok := t.Claims.(*Claims); ok && t.Valid {
 		return claims, nil
-	} else {
-		return nil, err
 	}
+	return nil, errors.New("invalid token")
+}
+
+// JWKS 返回所有非对称密钥的公钥，供其他服务校验本服务签发的token
+// HS256密钥是共享密钥，不会公开
+func (m *JWTManager) JWKS() []JWK {
+	keys := make([]JWK, 0, len(m.keys))
+	for _, kid := range slices.Sorted(maps.Keys(m.keys)) {
+		key := m.keys[kid]
+		jwk := JWK{Kid: key.kid, Alg: key.method.Alg(), Use: "sig"}
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	return keys
 }
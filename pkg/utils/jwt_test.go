@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tx/internal/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePEM stores a DER-encoded key as a PEM file and returns its path.
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func jwtConfig(signing string, keys ...config.JWTKeyConfig) *config.Config {
+	return &config.Config{Auth: config.AuthConfig{JWT: config.JWTConfig{
+		Issuer:         "tx",
+		AccessTokenTTL: time.Hour,
+		SigningKey:     signing,
+		Keys:           keys,
+	}}}
+}
+
+func TestJWTManager(t *testing.T) {
+	t.Setenv("TEST_JWT_SECRET", strings.Repeat("s", 32))
+	hmacKey := config.JWTKeyConfig{KID: "hs", Algorithm: JWTAlgorithmHS256, SecretEnv: "TEST_JWT_SECRET"}
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPEM := writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPrivate))
+	rsaKey := config.JWTKeyConfig{KID: "rsa", Algorithm: JWTAlgorithmRS256, PrivateKeyFile: rsaPEM}
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edPrivate)
+	require.NoError(t, err)
+	edKey := config.JWTKeyConfig{KID: "ed", Algorithm: JWTAlgorithmEdDSA,
+		PrivateKeyFile: writePEM(t, "ed.pem", "PRIVATE KEY", edDER)}
+	edPublicDER, err := x509.MarshalPKIXPublicKey(edPublic)
+	require.NoError(t, err)
+	edVerifyOnly := config.JWTKeyConfig{KID: "ed", Algorithm: JWTAlgorithmEdDSA,
+		PublicKeyFile: writePEM(t, "ed.pub.pem", "PUBLIC KEY", edPublicDER)}
+
+	for _, key := range []config.JWTKeyConfig{hmacKey, rsaKey, edKey} {
+		t.Run(key.Algorithm, func(t *testing.T) {
+			m, err := NewJWTManager(jwtConfig(key.KID, key))
+			require.NoError(t, err)
+			token, err := m.GenerateToken("user-1", "alice", []string{"admin"}, []string{"system:read"})
+			require.NoError(t, err)
+
+			claims, err := m.ParseToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, "user-1", claims.UserId)
+			assert.Equal(t, "user-1", claims.Subject)
+			assert.Equal(t, "alice", claims.Username)
+			assert.Equal(t, []string{"admin"}, claims.Roles)
+			assert.Equal(t, []string{"system:read"}, claims.Scopes)
+			assert.Equal(t, "tx", claims.Issuer)
+			assert.NotEmpty(t, claims.ID)
+
+			other, err := m.GenerateToken("user-1", "alice", nil, nil)
+			require.NoError(t, err)
+			otherClaims, err := m.ParseToken(other)
+			require.NoError(t, err)
+			assert.NotEqual(t, claims.ID, otherClaims.ID, "Every token needs its own jti for revocation")
+		})
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		before, err := NewJWTManager(jwtConfig("ed", edKey))
+		require.NoError(t, err)
+		oldToken, err := before.GenerateToken("user-1", "alice", nil, nil)
+		require.NoError(t, err)
+
+		// After rotating to the RSA key, the retired Ed25519 key is kept for verification only.
+		after, err := NewJWTManager(jwtConfig("rsa", rsaKey, edVerifyOnly))
+		require.NoError(t, err)
+		claims, err := after.ParseToken(oldToken)
+		require.NoError(t, err)
+		assert.Equal(t, "user-1", claims.UserId)
+
+		newToken, err := after.GenerateToken("user-2", "bob", nil, nil)
+		require.NoError(t, err)
+		header, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+		require.NoError(t, err)
+		assert.Equal(t, "rsa", header.Header["kid"])
+
+		// Once the retired key is removed its tokens are rejected.
+		retired, err := NewJWTManager(jwtConfig("rsa", rsaKey))
+		require.NoError(t, err)
+		_, err = retired.ParseToken(oldToken)
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsForgedTokens", func(t *testing.T) {
+		m, err := NewJWTManager(jwtConfig("rsa", rsaKey, hmacKey))
+		require.NoError(t, err)
+
+		// An HS256 token that claims the RSA kid must not be verified with the public key as HMAC secret.
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserId: "attacker", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "tx", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}})
+		forged.Header["kid"] = "rsa"
+		signed, err := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaPrivate.PublicKey))
+		require.NoError(t, err)
+		_, err = m.ParseToken(signed)
+		assert.Error(t, err)
+
+		// Tokens without a kid, such as those from the old hard-coded secret, are rejected.
+		legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserId: "user-1", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "tx", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		}})
+		signed, err = legacy.SignedString([]byte(strings.Repeat("s", 32)))
+		require.NoError(t, err)
+		_, err = m.ParseToken(signed)
+		assert.Error(t, err)
+
+		expired := jwt.NewWithClaims(jwt.SigningMethodRS256, Claims{UserId: "user-1", RegisteredClaims: jwt.RegisteredClaims{
+			Issuer: "tx", ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		}})
+		expired.Header["kid"] = "rsa"
+		signed, err = expired.SignedString(rsaPrivate)
+		require.NoError(t, err)
+		_, err = m.ParseToken(signed)
+		assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+	})
+
+	t.Run("JWKS", func(t *testing.T) {
+		m, err := NewJWTManager(jwtConfig("hs", hmacKey, rsaKey, edVerifyOnly))
+		require.NoError(t, err)
+		keys := m.JWKS()
+		require.Len(t, keys, 2, "Symmetric keys must not be published")
+		assert.Equal(t, JWK{Kty: "OKP", Kid: "ed", Alg: "EdDSA", Use: "sig", Crv: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(edPublic)}, keys[0])
+		assert.Equal(t, "RSA", keys[1].Kty)
+		assert.Equal(t, "rsa", keys[1].Kid)
+		assert.Equal(t, "AQAB", keys[1].E)
+		assert.Equal(t, base64.RawURLEncoding.EncodeToString(rsaPrivate.N.Bytes()), keys[1].N)
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		t.Setenv("SHORT_JWT_SECRET", "short")
+		invalid := []*config.Config{
+			jwtConfig("missing", hmacKey),
+			jwtConfig("ed", edVerifyOnly),
+			jwtConfig("hs", config.JWTKeyConfig{KID: "hs", Algorithm: JWTAlgorithmHS256, SecretEnv: "SHORT_JWT_SECRET"}),
+			jwtConfig("hs", config.JWTKeyConfig{KID: "hs", Algorithm: JWTAlgorithmHS256, SecretEnv: "UNSET_JWT_SECRET"}),
+			jwtConfig("hs", hmacKey, hmacKey),
+			jwtConfig("x", config.JWTKeyConfig{KID: "x", Algorithm: "none"}),
+		}
+		for _, cfg := range invalid {
+			_, err := NewJWTManager(cfg)
+			assert.Error(t, err, "%+v", cfg.Auth.JWT)
+		}
+	})
+}
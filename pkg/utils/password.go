@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"tx/internal/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 支持的密码哈希算法
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+)
+
+// legacySHA256Prefix 迁移后的旧版无盐SHA-256哈希前缀，未加前缀的64位十六进制串同样按旧格式处理
+const legacySHA256Prefix = "$sha256$"
+
+var (
+	// ErrUnknownPasswordHash 存储的哈希格式无法识别
+	ErrUnknownPasswordHash = errors.New("unknown password hash format")
+	// ErrPasswordTooLong 密码超过bcrypt能处理的72字节
+	ErrPasswordTooLong = bcrypt.ErrPasswordTooLong
+)
+
+// PasswordHasher 密码哈希算法
+type PasswordHasher interface {
+	// Hash 使用随机盐计算密码哈希，结果为包含算法和参数的PHC格式字符串
+	Hash(password string) (string, error)
+	// Verify 校验密码，rehash表示哈希使用了旧算法或旧参数，应在校验通过后重新计算并保存
+	Verify(password, encoded string) (ok, rehash bool, err error)
+}
+
+// NewPasswordHasher 根据配置创建密码哈希器
+// 新密码使用配置的算法，校验时按哈希格式识别算法，因此旧的哈希仍然可以登录
+func NewPasswordHasher(cfg *config.Config) (PasswordHasher, error) {
+	pc := cfg.Auth.Password
+	h := &passwordHashers{
+		argon2id: &Argon2idHasher{
+			Time:       pc.Argon2id.Time,
+			Memory:     pc.Argon2id.Memory,
+			Threads:    pc.Argon2id.Threads,
+			KeyLength:  pc.Argon2id.KeyLength,
+			SaltLength: pc.Argon2id.SaltLength,
+		},
+		bcrypt: &BcryptHasher{Cost: pc.BcryptCost},
+	}
+	if h.argon2id.Time == 0 || h.argon2id.Memory == 0 || h.argon2id.Threads == 0 ||
+		h.argon2id.KeyLength == 0 || h.argon2id.SaltLength == 0 {
+		return nil, fmt.Errorf("invalid argon2id parameters %+v", pc.Argon2id)
+	}
+	if pc.BcryptCost < bcrypt.MinCost || pc.BcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	switch pc.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		h.preferred = h.argon2id
+	case PasswordAlgorithmBcrypt:
+		h.preferred = h.bcrypt
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm %q", pc.Algorithm)
+	}
+	return h, nil
+}
+
+// passwordHashers 按哈希格式分派到对应的算法
+type passwordHashers struct {
+	preferred PasswordHasher
+	argon2id  *Argon2idHasher
+	bcrypt    *BcryptHasher
+}
+
+// Hash 使用配置的算法计算哈希
+func (h *passwordHashers) Hash(password string) (string, error) {
+	return h.preferred.Hash(password)
+}
+
+// Verify 识别哈希的算法并校验，不是当前算法的哈希都需要重新计算
+func (h *passwordHashers) Verify(password, encoded string) (bool, bool, error) {
+	var current PasswordHasher
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		current = h.argon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		current = h.bcrypt
+	case isLegacySHA256(encoded):
+		sum := sha256.Sum256([]byte(password))
+		expected := strings.TrimPrefix(encoded, legacySHA256Prefix)
+		ok := subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(expected))) == 1
+		return ok, true, nil
+	default:
+		return false, false, ErrUnknownPasswordHash
+	}
+	ok, rehash, err := current.Verify(password, encoded)
+	return ok, rehash || current != h.preferred, err
+}
+
+// isLegacySHA256 判断是否为旧版EncryptPassword生成的十六进制SHA-256摘要
+func isLegacySHA256(encoded string) bool {
+	digest := strings.TrimPrefix(encoded, legacySHA256Prefix)
+	if len(digest) != hex.EncodedLen(sha256.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(digest)
+	return err == nil
+}
+
+// Argon2idHasher 使用argon2id计算密码哈希
+// 格式为 $argon2id$v=19$m=<内存KiB>,t=<迭代次数>,p=<并行度>$<盐>$<哈希>，盐和哈希为无填充base64
+type Argon2idHasher struct {
+	Time       uint32
+	Memory     uint32 // KiB
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+// Hash 计算argon2id哈希
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify 使用哈希中记录的参数重新计算并比较，参数与当前配置不同时需要重新计算
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return false, false, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false, fmt.Errorf("invalid argon2id parameters %q: %w", parts[3], err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid argon2id hash: %w", err)
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	ok := subtle.ConstantTimeCompare(actual, key) == 1
+	rehash := memory != h.Memory || time != h.Time || threads != h.Threads ||
+		uint32(len(key)) != h.KeyLength || uint32(len(salt)) != h.SaltLength
+	return ok, rehash, nil
+}
+
+// BcryptHasher 使用bcrypt计算密码哈希，bcrypt只使用密码的前72个字节，更长的密码会被拒绝
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash 计算bcrypt哈希
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Verify 校验bcrypt哈希，cost与当前配置不同时需要重新计算
+func (h *BcryptHasher) Verify(password, encoded string) (bool, bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return false, false, err
+	}
+	return true, cost != h.Cost, nil
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"tx/internal/config"
+)
+
+// 违反密码策略的原因，作为errdetails.BadRequest中FieldViolation的Reason返回给客户端
+const (
+	PasswordTooShort         = "PASSWORD_TOO_SHORT"
+	PasswordTooLong          = "PASSWORD_TOO_LONG"
+	PasswordMissingUpper     = "PASSWORD_MISSING_UPPERCASE"
+	PasswordMissingLower     = "PASSWORD_MISSING_LOWERCASE"
+	PasswordMissingDigit     = "PASSWORD_MISSING_DIGIT"
+	PasswordMissingSymbol    = "PASSWORD_MISSING_SYMBOL"
+	PasswordContainsUsername = "PASSWORD_CONTAINS_USERNAME"
+	PasswordBreached         = "PASSWORD_BREACHED"
+)
+
+// minUsernameMatchLength 用户名至少有这么多字符时才检查密码是否包含用户名，避免过短的用户名误伤
+const minUsernameMatchLength = 3
+
+// PolicyViolation 一条不满足的策略规则
+type PolicyViolation struct {
+	Reason      string
+	Description string
+}
+
+// PasswordPolicy 设置或修改密码时检查密码强度
+type PasswordPolicy struct {
+	cfg      config.PasswordPolicyConfig
+	breached map[[sha1.Size]byte]struct{} // 泄露密码的SHA-1
+}
+
+// NewPasswordPolicy 根据配置创建密码策略，配置了泄露密码文件时一次性加载到内存
+func NewPasswordPolicy(cfg *config.Config) (*PasswordPolicy, error) {
+	pc := cfg.Auth.Password.Policy
+	if pc.MinLength < 0 || pc.MaxLength < 0 || (pc.MaxLength > 0 && pc.MaxLength < pc.MinLength) {
+		return nil, fmt.Errorf("invalid password length limits [%d, %d]", pc.MinLength, pc.MaxLength)
+	}
+	p := &PasswordPolicy{cfg: pc}
+	if pc.BreachedPasswordsFile != "" {
+		breached, err := loadBreachedPasswords(pc.BreachedPasswordsFile)
+		if err != nil {
+			return nil, fmt.Errorf("load breached passwords: %w", err)
+		}
+		p.breached = breached
+	}
+	return p, nil
+}
+
+// loadBreachedPasswords 读取泄露密码列表，每行一个明文密码，
+// 或者Have I Been Pwned格式的SHA-1（40位十六进制，可带 :次数 后缀），空行和#开头的行会被忽略
+func loadBreachedPasswords(path string) (map[[sha1.Size]byte]struct{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	breached := make(map[[sha1.Size]byte]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if sum, ok := parseSHA1Line(line); ok {
+			breached[sum] = struct{}{}
+			continue
+		}
+		breached[sha1.Sum([]byte(line))] = struct{}{}
+	}
+	return breached, scanner.Err()
+}
+
+// parseSHA1Line 解析 HASH 或 HASH:次数 格式的行
+func parseSHA1Line(line string) ([sha1.Size]byte, bool) {
+	var sum [sha1.Size]byte
+	hash, _, _ := strings.Cut(line, ":")
+	if len(hash) != hex.EncodedLen(sha1.Size) {
+		return sum, false
+	}
+	if _, err := hex.Decode(sum[:], []byte(hash)); err != nil {
+		return sum, false
+	}
+	return sum, true
+}
+
+// Validate 返回密码违反的所有规则，满足策略时返回nil
+// 长度按字符计算而不是字节
+func (p *PasswordPolicy) Validate(username, password string) []PolicyViolation {
+	var violations []PolicyViolation
+	add := func(reason, format string, args ...any) {
+		violations = append(violations, PolicyViolation{Reason: reason, Description: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.cfg.MinLength {
+		add(PasswordTooShort, "password must be at least %d characters", p.cfg.MinLength)
+	}
+	if p.cfg.MaxLength > 0 && length > p.cfg.MaxLength {
+		add(PasswordTooLong, "password must be at most %d characters", p.cfg.MaxLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.cfg.RequireUpper && !upper {
+		add(PasswordMissingUpper, "password must contain an uppercase letter")
+	}
+	if p.cfg.RequireLower && !lower {
+		add(PasswordMissingLower, "password must contain a lowercase letter")
+	}
+	if p.cfg.RequireDigit && !digit {
+		add(PasswordMissingDigit, "password must contain a digit")
+	}
+	if p.cfg.RequireSymbol && !symbol {
+		add(PasswordMissingSymbol, "password must contain a symbol")
+	}
+
+	if containsUsername(username, password) {
+		add(PasswordContainsUsername, "password must not contain the username")
+	}
+	if _, ok := p.breached[sha1.Sum([]byte(password))]; ok {
+		add(PasswordBreached, "password appears in a list of breached passwords")
+	}
+	return violations
+}
+
+// containsUsername 密码与用户名相同或包含用户名（不区分大小写）
+func containsUsername(username, password string) bool {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if username == "" {
+		return false
+	}
+	password = strings.ToLower(password)
+	if password == username {
+		return true
+	}
+	return utf8.RuneCountInString(username) >= minUsernameMatchLength && strings.Contains(password, username)
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tx/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func policyConfig(pc config.PasswordPolicyConfig) *config.Config {
+	return &config.Config{Auth: config.AuthConfig{Password: config.PasswordConfig{Policy: pc}}}
+}
+
+func reasons(violations []PolicyViolation) []string {
+	var result []string
+	for _, v := range violations {
+		result = append(result, v.Reason)
+	}
+	return result
+}
+
+func TestPasswordPolicy_Validate(t *testing.T) {
+	sum := sha1.Sum([]byte("P@ssw0rd!"))
+	breachedFile := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(breachedFile, []byte(strings.Join([]string{
+		"# common passwords",
+		"",
+		"correcthorsebatterystaple",
+		strings.ToUpper(hex.EncodeToString(sum[:])) + ":3861493",
+	}, "\n")), 0o600))
+
+	policy, err := NewPasswordPolicy(policyConfig(config.PasswordPolicyConfig{
+		MinLength:             8,
+		MaxLength:             16,
+		RequireUpper:          true,
+		RequireLower:          true,
+		RequireDigit:          true,
+		RequireSymbol:         true,
+		BreachedPasswordsFile: breachedFile,
+	}))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     []string
+	}{
+		{"Valid", "alice", "Tr0ub4dor&3", nil},
+		{"TooShort", "alice", "Aa1!", []string{PasswordTooShort}},
+		{"TooLong", "alice", "Tr0ub4dor&3Tr0ub4dor&3", []string{PasswordTooLong}},
+		{"LengthCountsCharacters", "alice", "Пароль1!Пар", nil},
+		{"MissingClasses", "alice", "abcdefghij", []string{PasswordMissingUpper, PasswordMissingDigit, PasswordMissingSymbol}},
+		{"OnlyUppercase", "alice", "ABCDEFGHIJ", []string{PasswordMissingLower, PasswordMissingDigit, PasswordMissingSymbol}},
+		{"SameAsUsername", "Alice.Smith1", "alice.smith1", []string{PasswordMissingUpper, PasswordContainsUsername}},
+		{"ContainsUsername", "alice", "Alice2024!", []string{PasswordContainsUsername}},
+		{"ShortUsernameNotMatched", "al", "Always1234!", nil},
+		{"BreachedPlaintext", "alice", "correcthorsebatterystaple", []string{PasswordTooLong, PasswordMissingUpper, PasswordMissingDigit, PasswordMissingSymbol, PasswordBreached}},
+		{"BreachedSHA1", "alice", "P@ssw0rd!", []string{PasswordBreached}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := policy.Validate(tt.username, tt.password)
+			assert.Equal(t, tt.want, reasons(violations))
+			for _, v := range violations {
+				assert.NotEmpty(t, v.Description)
+			}
+		})
+	}
+}
+
+func TestNewPasswordPolicy_InvalidConfig(t *testing.T) {
+	invalid := []config.PasswordPolicyConfig{
+		{MinLength: -1},
+		{MinLength: 12, MaxLength: 8},
+		{BreachedPasswordsFile: filepath.Join(t.TempDir(), "missing.txt")},
+	}
+	for _, pc := range invalid {
+		_, err := NewPasswordPolicy(policyConfig(pc))
+		assert.Error(t, err, "%+v", pc)
+	}
+
+	// Without a max length or class requirements only the minimum length applies.
+	policy, err := NewPasswordPolicy(policyConfig(config.PasswordPolicyConfig{MinLength: 8}))
+	require.NoError(t, err)
+	assert.Empty(t, policy.Validate("alice", strings.Repeat("x", 1000)))
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"tx/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPasswordConfig uses cheap parameters so the tests stay fast.
+func testPasswordConfig(algorithm string) *config.Config {
+	return &config.Config{Auth: config.AuthConfig{Password: config.PasswordConfig{
+		Algorithm:  algorithm,
+		Argon2id:   config.Argon2idConfig{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16},
+		BcryptCost: 4,
+	}}}
+}
+
+func TestPasswordHasher(t *testing.T) {
+	for _, algorithm := range []string{PasswordAlgorithmArgon2id, PasswordAlgorithmBcrypt} {
+		t.Run(algorithm, func(t *testing.T) {
+			hasher, err := NewPasswordHasher(testPasswordConfig(algorithm))
+			require.NoError(t, err)
+
+			first, err := hasher.Hash("correct horse")
+			require.NoError(t, err)
+			second, err := hasher.Hash("correct horse")
+			require.NoError(t, err)
+			assert.NotEqual(t, first, second, "Each hash must use a fresh salt")
+
+			ok, rehash, err := hasher.Verify("correct horse", first)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.False(t, rehash)
+
+			ok, _, err = hasher.Verify("wrong horse", first)
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+
+	t.Run("Argon2idFormat", func(t *testing.T) {
+		hasher, err := NewPasswordHasher(testPasswordConfig(PasswordAlgorithmArgon2id))
+		require.NoError(t, err)
+		encoded, err := hasher.Hash("secret")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"), encoded)
+	})
+
+	t.Run("LegacySHA256", func(t *testing.T) {
+		hasher, err := NewPasswordHasher(testPasswordConfig(PasswordAlgorithmArgon2id))
+		require.NoError(t, err)
+		sum := sha256.Sum256([]byte("secret"))
+		legacy := hex.EncodeToString(sum[:])
+
+		for _, encoded := range []string{legacy, legacySHA256Prefix + legacy} {
+			ok, rehash, err := hasher.Verify("secret", encoded)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.True(t, rehash, "Legacy hashes must always be upgraded")
+
+			ok, _, err = hasher.Verify("other", encoded)
+			require.NoError(t, err)
+			assert.False(t, ok)
+		}
+	})
+
+	t.Run("RehashOnAlgorithmOrParameterChange", func(t *testing.T) {
+		bcryptHasher, err := NewPasswordHasher(testPasswordConfig(PasswordAlgorithmBcrypt))
+		require.NoError(t, err)
+		argonHasher, err := NewPasswordHasher(testPasswordConfig(PasswordAlgorithmArgon2id))
+		require.NoError(t, err)
+
+		fromBcrypt, err := bcryptHasher.Hash("secret")
+		require.NoError(t, err)
+		ok, rehash, err := argonHasher.Verify("secret", fromBcrypt)
+		require.NoError(t, err)
+		assert.True(t, ok, "Hashes from the previous algorithm must still verify")
+		assert.True(t, rehash)
+
+		stronger := testPasswordConfig(PasswordAlgorithmArgon2id)
+		stronger.Auth.Password.Argon2id.Time = 2
+		strongerHasher, err := NewPasswordHasher(stronger)
+		require.NoError(t, err)
+		fromArgon, err := argonHasher.Hash("secret")
+		require.NoError(t, err)
+		ok, rehash, err = strongerHasher.Verify("secret", fromArgon)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, rehash)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		hasher, err := NewPasswordHasher(testPasswordConfig(PasswordAlgorithmBcrypt))
+		require.NoError(t, err)
+		_, _, err = hasher.Verify("secret", "plaintext")
+		assert.ErrorIs(t, err, ErrUnknownPasswordHash)
+		_, err = hasher.Hash(strings.Repeat("a", 73))
+		assert.ErrorIs(t, err, ErrPasswordTooLong)
+
+		_, err = NewPasswordHasher(testPasswordConfig("md5"))
+		assert.Error(t, err)
+		invalid := testPasswordConfig(PasswordAlgorithmArgon2id)
+		invalid.Auth.Password.Argon2id.Memory = 0
+		_, err = NewPasswordHasher(invalid)
+		assert.Error(t, err)
+	})
+}
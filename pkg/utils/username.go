@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"tx/internal/config"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// 违反用户名规则的原因，作为errdetails.BadRequest中FieldViolation的Reason返回给客户端
+const (
+	UsernameTooShort         = "USERNAME_TOO_SHORT"
+	UsernameTooLong          = "USERNAME_TOO_LONG"
+	UsernameInvalidCharacter = "USERNAME_INVALID_CHARACTER"
+	UsernameInvalidBoundary  = "USERNAME_INVALID_BOUNDARY"
+	UsernameReserved         = "USERNAME_RESERVED"
+)
+
+// CleanUsername 返回保存和展示用的用户名：去掉首尾空白并做NFKC规范化，保留大小写
+func CleanUsername(username string) string {
+	return norm.NFKC.String(strings.TrimSpace(username))
+}
+
+// NormalizeUsername 返回用户名的规范形式：在CleanUsername的基础上做大小写折叠
+// 规范形式相同的用户名视为同一个用户，用于唯一索引、查询和Redis键
+func NormalizeUsername(username string) string {
+	// 大小写折叠可能产生非规范化的序列，需要再规范化一次
+	return norm.NFKC.String(cases.Fold().String(CleanUsername(username)))
+}
+
+// UsernamePolicy 注册时检查用户名
+type UsernamePolicy struct {
+	cfg      config.UsernameConfig
+	symbols  string              // 字母和数字之外允许的字符
+	reserved map[string]struct{} // 保留用户名的规范形式
+}
+
+// NewUsernamePolicy 根据配置创建用户名规则
+func NewUsernamePolicy(cfg *config.Config) (*UsernamePolicy, error) {
+	uc := cfg.Auth.Username
+	if uc.MinLength <= 0 || uc.MaxLength < uc.MinLength {
+		return nil, fmt.Errorf("invalid username length limits [%d, %d]", uc.MinLength, uc.MaxLength)
+	}
+	for _, r := range uc.AllowedSymbols {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return nil, fmt.Errorf("invalid username symbol %q", r)
+		}
+	}
+	p := &UsernamePolicy{
+		cfg:      uc,
+		symbols:  uc.AllowedSymbols,
+		reserved: make(map[string]struct{}, len(uc.Reserved)),
+	}
+	for _, name := range uc.Reserved {
+		p.reserved[NormalizeUsername(name)] = struct{}{}
+	}
+	return p, nil
+}
+
+// Validate 检查规范化后的用户名，返回违反的所有规则
+// 用户名只能包含字母（及其组合符号）、数字和allowed_symbols中的字符，并且必须以字母或数字开头和结尾
+func (p *UsernamePolicy) Validate(normalized string) []PolicyViolation {
+	var violations []PolicyViolation
+	add := func(reason, format string, args ...any) {
+		violations = append(violations, PolicyViolation{Reason: reason, Description: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(normalized)
+	if length < p.cfg.MinLength {
+		add(UsernameTooShort, "username must be at least %d characters", p.cfg.MinLength)
+	}
+	if length > p.cfg.MaxLength {
+		add(UsernameTooLong, "username must be at most %d characters", p.cfg.MaxLength)
+	}
+	for _, r := range normalized {
+		if !isAlphanumeric(r) && !unicode.IsMark(r) && !strings.ContainsRune(p.symbols, r) {
+			add(UsernameInvalidCharacter, "username must not contain %q", r)
+			break
+		}
+	}
+	if length > 0 {
+		first, _ := utf8.DecodeRuneInString(normalized)
+		last, _ := utf8.DecodeLastRuneInString(normalized)
+		if !isAlphanumeric(first) || !(isAlphanumeric(last) || unicode.IsMark(last)) {
+			add(UsernameInvalidBoundary, "username must start and end with a letter or digit")
+		}
+	}
+	if _, ok := p.reserved[normalized]; ok {
+		add(UsernameReserved, "username is reserved")
+	}
+	return violations
+}
+
+// isAlphanumeric 是否为任意文字的字母或十进制数字
+func isAlphanumeric(r rune) bool {
+	return unicode.IsLetter(r) || unicode.Is(unicode.Nd, r)
+}
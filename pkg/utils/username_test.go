@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"testing"
+
+	"tx/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"alice", "alice"},
+		{"Alice", "alice"},
+		{"  alice \t", "alice"},
+		{"ＡＬＩＣＥ", "alice"},     // fullwidth letters
+		{"Straße", "strasse"},  // ß folds to ss
+		{"café", "café"},      // combining accent is composed
+		{"ﬁle", "file"},        // ligature is decomposed by NFKC
+		{"ΣΊΣΥΦΟΣ", "σίσυφοσ"}, // final sigma folds like any sigma
+		{"", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NormalizeUsername(tt.in), "%q", tt.in)
+	}
+	assert.Equal(t, NormalizeUsername("Alice"), NormalizeUsername("alice "), "Variants of one name must share a key")
+	assert.Equal(t, "Alice", CleanUsername(" Alice "), "The stored name keeps its case")
+}
+
+func TestUsernamePolicy_Validate(t *testing.T) {
+	policy, err := NewUsernamePolicy(&config.Config{Auth: config.AuthConfig{Username: config.UsernameConfig{
+		MinLength:      3,
+		MaxLength:      12,
+		AllowedSymbols: "._-",
+		Reserved:       []string{"Admin", "root"},
+	}}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		username string
+		want     []string
+	}{
+		{"Valid", "alice", nil},
+		{"TooLongWithSymbols", "alice.smith_1", []string{UsernameTooLong}},
+		{"AllowedSymbolInside", "a.b-c_d", nil},
+		{"Unicode", "张伟", []string{UsernameTooShort}},
+		{"UnicodeLetters", "müller", nil},
+		{"CombiningMarks", "नमस्ते", nil},
+		{"TooShort", "al", []string{UsernameTooShort}},
+		{"Space", "alice smith", []string{UsernameInvalidCharacter}},
+		{"KeyDelimiter", "alice:1", []string{UsernameInvalidCharacter}},
+		{"Emoji", "alice😀", []string{UsernameInvalidCharacter, UsernameInvalidBoundary}},
+		{"LeadingSymbol", ".alice", []string{UsernameInvalidBoundary}},
+		{"TrailingSymbol", "alice-", []string{UsernameInvalidBoundary}},
+		{"Reserved", NormalizeUsername("ADMIN"), []string{UsernameReserved}},
+		{"ReservedLowercase", "root", []string{UsernameReserved}},
+		{"Empty", "", []string{UsernameTooShort}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, reasons(policy.Validate(tt.username)))
+		})
+	}
+}
+
+func TestNewUsernamePolicy_InvalidConfig(t *testing.T) {
+	invalid := []config.UsernameConfig{
+		{MinLength: 0, MaxLength: 10},
+		{MinLength: 5, MaxLength: 3},
+		{MinLength: 3, MaxLength: 10, AllowedSymbols: "a"},
+		{MinLength: 3, MaxLength: 10, AllowedSymbols: " "},
+	}
+	for _, uc := range invalid {
+		_, err := NewUsernamePolicy(&config.Config{Auth: config.AuthConfig{Username: uc}})
+		assert.Error(t, err, "%+v", uc)
+	}
+}
@@ -6,13 +6,13 @@ USER_PROTO_PATH="proto/user.proto"
 SYSTEM_PROTO_PATH="proto/system.proto"
 
 LOGIN_USERNAME="test"
-LOGIN_PASSWORD="test"
+LOGIN_PASSWORD="Grpc-Stress-2024!" # 需要满足 auth.password.policy（默认至少8个字符且不包含用户名），与 test_server.sh 注册的密码一致
 
-# 这是客户端请求服务器发送的文件路径，服务器需要能访问此路径下的文件
-FILE_PATH_ON_SERVER="test.txt" # 请修改为服务器上实际存在的文件路径
+# 这是客户端请求服务器发送的文件路径，相对于服务器配置的 system.file_roots（默认 ./data）
+FILE_PATH_ON_SERVER="test.txt" # 请修改为服务器文件根目录下实际存在的文件路径
 
 # ghz 参数
-CONCURRENT_REQUESTS=10 # ghz 并发请求数
+CONCURRENT_REQUESTS=10 # ghz 并发请求数（超过服务端 system.transfer.max_streams_per_user 的流会被拒绝，返回 ResourceExhausted）
 TOTAL_REQUESTS=100     # ghz 总请求数
 
 # --- 脚本开始 ---
@@ -22,6 +22,16 @@ TOTAL_REQUESTS=100     # ghz 总请求数
 # 这里仅为演示，如果你的服务器需要一个真实文件，请确保它存在。
 # echo "This is a test file for streaming." > "$FILE_PATH_ON_SERVER" # 取消注释并在服务器端有权限的地方创建
 
+# SystemService 的所有方法都需要 ops 或 admin 角色（auth.method_policies），角色在登录时写入Token，必须先授予再登录
+# 授予角色的命令按实际的数据库连接方式修改，默认连接 docker-compose 启动的 postgres_db
+LOGIN_ROLE="ops"
+echo "正在为用户 '$LOGIN_USERNAME' 授予角色 '$LOGIN_ROLE'..."
+if ! echo "UPDATE users SET roles = ARRAY['${LOGIN_ROLE}'] WHERE username_normalized = lower('${LOGIN_USERNAME}');" |
+    docker exec -i postgres_db psql -U mkitsdts -d tx_test -v ON_ERROR_STOP=1 -qtA; then
+    echo "授予角色失败，SendFile 会返回 PermissionDenied。"
+    exit 1
+fi
+
 # 用户登录并获取Token (使用 grpcurl)
 echo "正在登录用户 '$LOGIN_USERNAME'..."
 LOGIN_RESPONSE=$(grpcurl -plaintext \
@@ -44,15 +54,34 @@ if [ -z "$TOKEN" ] || [ "$TOKEN" == "null" ]; then
 fi
 echo "登录成功! Token: $TOKEN"
 
-# 使用 grpcurl 发送文件请求并保存响应到临时目录
-grpcurl -plaintext \
+# 使用 grpcurl 下载文件，-v 会同时输出响应头（文件元数据）和响应尾（实际发送内容的摘要）
+mkdir -p temp
+grpcurl -plaintext -v \
   -H "Authorization: $TOKEN" \
   -proto "${SYSTEM_PROTO_PATH}" \
   -d "{\"file_path\": \"${FILE_PATH_ON_SERVER}\"}" \
   "${GRPC_SERVER_ADDR}" \
-  system.SystemService.SendFile > "temp/test.txt"
+  system.SystemService.SendFile > "temp/sendfile_verbose.txt"
+
+# 按顺序解码每个文件块的 base64 内容，还原出文件
+grep -o '"content": "[^"]*"' "temp/sendfile_verbose.txt" | sed 's/"content": "\(.*\)"/\1/' |
+  while read -r chunk; do printf '%s' "$chunk" | base64 -d; done > "temp/test.txt"
 echo "使用grpcurl接收的文件保存在: temp/test.txt"
 
+# 校验下载结果：文件大小、响应头中的文件摘要、响应尾中的内容摘要必须与本地计算一致
+EXPECTED_SIZE=$(grep -m1 '^x-file-size:' "temp/sendfile_verbose.txt" | awk '{print $2}')
+EXPECTED_SHA256=$(grep -m1 '^x-file-sha256:' "temp/sendfile_verbose.txt" | awk '{print $2}')
+TRAILER_SHA256=$(grep -m1 '^x-content-sha256:' "temp/sendfile_verbose.txt" | awk '{print $2}')
+ACTUAL_SIZE=$(wc -c < "temp/test.txt" | tr -d ' ')
+ACTUAL_SHA256=$(sha256sum "temp/test.txt" | awk '{print $1}')
+if [ "$ACTUAL_SIZE" != "$EXPECTED_SIZE" ] || [ "$ACTUAL_SHA256" != "$EXPECTED_SHA256" ] || [ "$ACTUAL_SHA256" != "$TRAILER_SHA256" ]; then
+    echo "文件校验失败!"
+    echo "  大小: 期望 $EXPECTED_SIZE, 实际 $ACTUAL_SIZE"
+    echo "  SHA-256: 响应头 $EXPECTED_SHA256, 响应尾 $TRAILER_SHA256, 实际 $ACTUAL_SHA256"
+    exit 1
+fi
+echo "文件校验通过: ${ACTUAL_SIZE} 字节, SHA-256 ${ACTUAL_SHA256}"
+
 # 使用获取到的Token调用 SendFile (使用 ghz)
 echo "开始使用ghz对 SendFile 进行压力测试..."
 echo "请求服务器发送文件: $FILE_PATH_ON_SERVER"